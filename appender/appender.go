@@ -2,79 +2,493 @@
 package appender
 
 import (
-	"encoding/binary"
+	"container/list"
+	"errors"
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+var (
+	OffsetOutOfRange  = errors.New("appender: offset out of range")
+	BatchRecord       = errors.New("appender: record holds a batch, use ReadBatch")
+	CheckpointRecord  = errors.New("appender: record is a checkpoint, use LastCheckpoint")
+	NoCheckpoint      = errors.New("appender: no checkpoint found")
+	NoTrailer         = errors.New("appender: record written without trailer, it can not be read backwards")
+	InvalidName       = errors.New("appender: invalid name")
+	TooManyOpenFiles  = errors.New("appender: too many open files")
+	FileInUse         = errors.New("appender: file in use")
+	FileMapped        = errors.New("appender: file mapped in memory, close the mappings first")
+	NotAppenderFile   = errors.New("appender: not an appender file")
+	UnsupportedFormat = errors.New("appender: file written with a newer format")
+	LegacyFormat      = errors.New("appender: file written without checksums, it must be migrated")
+	RecordTooLarge    = errors.New("appender: record larger than MaxRecordSize")
+	Compacted         = errors.New("appender: record dropped by compaction")
+)
+
+// DefaultMaxRecordSize is the MaxRecordSize of a DB that does not set it.
+const DefaultMaxRecordSize = 64 << 20
+
 // DB just holds data common to the files
 type DB struct {
+	// Root is the directory holding the files and logs of the database. The
+	// names given to Open and OpenLog are escaped to always stay inside it.
+	Root string
+
+	// MaxOpenFiles limits the files opened with Open that are kept open.
+	// Closed files stay open until the limit is reached, so opening them
+	// again is free. Zero closes files as soon as they are not used.
+	MaxOpenFiles int
+
+	// MaxRecordSize is the largest data a record can hold: the data given
+	// to Append, or all the entries of a batch counting 8 more bytes for the
+	// length of each one. Writing more fails with RecordTooLarge, and
+	// reading a record that claims to hold more fails with a
+	// *RecordTooLargeError instead of allocating it. Zero means
+	// DefaultMaxRecordSize. Larger values than a record can hold, like
+	// math.MaxInt64, mean no limit.
+	MaxRecordSize int64
+
+	// Repair truncates a partial record found at the end of a file on Open
+	// (for example after a crash in the middle of a Write). Without it Open
+	// returns a *CorruptionError. Files written before records had
+	// checksums are never taken for damaged ones, see Migrate.
+	Repair bool
+
+	// Logs opened with OpenLog start a new segment once the current one would
+	// grow past SegmentSize bytes or is older than SegmentAge. Zero disables
+	// each threshold.
+	SegmentSize int64
+	SegmentAge  time.Duration
+
+	// Retention policy for logs. Old segments are removed once the log holds
+	// more than RetentionBytes or RetentionRecords without them, or when
+	// they were last written more than RetentionAge ago. The segment being
+	// written is never removed. Zero disables each limit.
+	RetentionBytes   int64
+	RetentionRecords int64
+	RetentionAge     time.Duration
+
+	// Compaction makes the janitor compact the open logs, keeping only the
+	// last record of every key. See Log.Compact.
+	Compaction bool
+
+	// TombstoneGrace is how long Compact keeps tombstones once they are the
+	// last record of their key, so readers get to see the key deleted. Zero
+	// drops them on the first compaction.
+	TombstoneGrace time.Duration
+
+	// Sync is the durability policy of the writes. See SyncPolicy.
+	Sync          SyncPolicy
+	SyncInterval  time.Duration // How long SyncGroup waits for more writers
+	SyncThreshold int64         // Bytes written before SyncBytes flushes
+
+	// Codec, if set, compresses the records written. Records that do not
+	// get smaller are stored as they are. Compressed records are read back
+	// transparently whatever the Codec of the DB is.
+	Codec Codec
+
+	// Keys, if set, encrypts the records written with AES-GCM. Encrypted
+	// records can not be read without it.
+	Keys KeyProvider
+
+	// Timestamps stores the time every record is appended in its Meta.
+	Timestamps bool
+
+	// Trailer repeats the length of every record written after its data.
+	// It takes 8 more bytes per record and allows reading a file backwards,
+	// see File.Reverse.
+	Trailer bool
+
+	// IndexInterval, if set, keeps a sparse index next to every file with
+	// the offset and time of one record every IndexInterval records. It
+	// speeds up SeekRecord and SeekTime and is rebuilt on Open if it is
+	// missing or out of date.
+	IndexInterval int64
+
+	// PollInterval is how often Follow checks the size of the file on disk,
+	// to see records written by other processes. Zero only wakes up on
+	// writes made through the same File.
+	PollInterval time.Duration
+
+	// OnRetention, if set, is called for every segment removed by the
+	// retention policy.
+	OnRetention func(RetentionEvent)
+
+	m     sync.Mutex
+	txm   sync.Mutex    // Serializes transactions
+	jm    sync.Mutex    // Protects txChecked
+	cm    sync.RWMutex  // Held by backups, so compactions do not replace the files they copy
+	om    sync.RWMutex  // Held by Open and OpenLog, and by backups until every size is taken
+	logs  map[*Log]bool // Open logs, for the janitor
+	stop  chan struct{}
+	files map[string]*File // Files returned by Open
+	idle  *list.List       // Files not in use, the most recently used first
+
+	txChecked bool // The journal was looked at, see recoverJournal
+}
+
+func (db *DB) maxRecordSize() int64 {
+	if db.MaxRecordSize <= 0 {
+		return DefaultMaxRecordSize
+	}
+	// So the size of a record with its overhead never overflows
+	return min(db.MaxRecordSize, lengthMask-recordOverhead)
+}
+
+// NewDB returns a database stored in root, creating the directory if needed.
+func NewDB(root string) (*DB, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	return &DB{Root: root}, nil
 }
 
-// Open a specific file in the database
+// Open a specific file in the database. Opening a file that is already open
+// returns the same *File; it is really closed once every Open is matched by
+// a Close.
 func (db *DB) Open(name string) (*File, error) {
+	path, err := db.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.recoverJournal(); err != nil {
+		return nil, err
+	}
+
+	db.om.RLock()
+	defer db.om.RUnlock()
+	db.m.Lock()
+	defer db.m.Unlock()
+	if f, ok := db.files[path]; ok {
+		f.refs++
+		if f.idle != nil {
+			db.idle.Remove(f.idle)
+			f.idle = nil
+		}
+		return f, nil
+	}
+
+	if db.MaxOpenFiles > 0 && len(db.files) >= db.MaxOpenFiles && !db.evict() {
+		return nil, TooManyOpenFiles
+	}
+	f, err := db.openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f.cached = true
+	f.refs = 1
+	if db.files == nil {
+		db.files = make(map[string]*File)
+		db.idle = list.New()
+	}
+	db.files[path] = f
+	return f, nil
+}
+
+func (db *DB) openFile(name string) (*File, error) {
+	return db.openFileRepair(name, db.Repair)
+}
+
+// openFileRepair is openFile choosing whether a partial record is repaired.
+func (db *DB) openFileRepair(name string, repair bool) (*File, error) {
 	f, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
 	if err != nil {
 		return nil, err
 	}
-	return &File{f: f}, nil
+	file, err := db.initFile(f, name, repair, true)
+	if err == LegacyFormat {
+		if err := db.migrateLegacy(name, repair); err != nil {
+			return nil, err
+		}
+		return db.openFileRepair(name, repair)
+	}
+	return file, err
+}
+
+// initFile reads the header of f and finds the end of its last record. Only
+// writable files get a header written if they are new, have a partial
+// record removed, and use an index.
+func (db *DB) initFile(f *os.File, name string, repair, writable bool) (*File, error) {
+	file := &File{f: f, name: name, db: db}
+	if err := file.readFileHeader(writable); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := file.recover(repair, writable); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if db.IndexInterval > 0 && writable {
+		if err := file.openIndex(); err != nil {
+			file.close()
+			return nil, err
+		}
+	}
+	return file, nil
 }
 
 // Remove a file from the database
 func (db *DB) Remove(name string) error {
-	return os.Remove(name)
+	path, err := db.path(name)
+	if err != nil {
+		return err
+	}
+	db.m.Lock()
+	if f, ok := db.files[path]; ok {
+		db.forget(f)
+	}
+	db.m.Unlock()
+	os.Remove(path + indexExt)
+	return os.Remove(path)
 }
 
 // File represent a basic file
 type File struct {
-	f *os.File
-	m sync.Mutex
+	f        *os.File
+	name     string
+	db       *DB
+	start    int64 // Size of the file header, where offset 0 is
+	version  int
+	features uint16       // Record flags used by the file, see encodeFileHeader
+	m        sync.Mutex   // Serializes writes
+	size     atomic.Int64 // End of the last complete record
+	records  atomic.Int64
+	wake     chan struct{} // Closed on the next write
+	syncer   syncer
+	index    *index // Nil unless DB.IndexInterval is set
+	maps     int    // Mappings not closed yet, see Map
+	mapped   int64  // End of the largest of them
+
+	// Files returned by DB.Open are shared, see DB.release
+	cached bool
+	refs   int
+	idle   *list.Element
+}
+
+// recover finds the end of the last complete record. Anything after it is
+// the leftover of an interrupted Write. It is only removed from writable
+// files; the others are read as if it was not there.
+func (f *File) recover(repair, writable bool) error {
+	info, err := f.f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size() - f.start
+
+	var offset, last, records int64 = 0, -1, 0
+	var hdr [headerSize]byte
+	for size-offset >= headerSize {
+		if _, err := f.readAt(hdr[:], offset); err != nil {
+			return err
+		}
+		n, flags, _ := decodeHeader(hdr[:])
+		if flags&^knownFlags != 0 || recordSize(n, flags) > size-offset {
+			break
+		}
+		last = offset
+		offset += recordSize(n, flags)
+		records++
+	}
+
+	// The length of the last record may have reached the disk before its
+	// data did, so its checksum is verified too.
+	if offset == size && last >= 0 {
+		if _, _, err := readRecord(f.section(last, size-last), last, size-last, lengthMask); err != nil {
+			if _, ok := err.(*CorruptionError); !ok {
+				return err
+			}
+			offset = last
+			records--
+		}
+	}
+
+	if offset != size {
+		if !repair {
+			return &CorruptionError{Offset: offset, Reason: "incomplete record at end of file", short: true}
+		}
+		if writable {
+			if err := f.f.Truncate(f.start + offset); err != nil {
+				return err
+			}
+		}
+	}
+	f.size.Store(offset)
+	f.records.Store(records)
+	return nil
 }
 
 // Write at the end data into the file
 func (f *File) Write(data []byte) (n int, err error) {
-	f.m.Lock()
-	defer f.m.Unlock()
+	if _, err = f.Append(data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
 
-	err = binary.Write(f.f, binary.LittleEndian, int64(len(data)))
+// Append writes data at the end of the file and returns the offset where its
+// record starts. The offset can be given later to ReadEntry or IterateFrom.
+func (f *File) Append(data []byte) (offset int64, err error) {
+	record, err := f.db.frame(0, nil, data)
 	if err != nil {
 		return 0, err
 	}
-	return f.f.Write(data)
+	offset, err = f.write(record, 1)
+	if err != nil {
+		return 0, err
+	}
+	return offset, f.durable(offset + int64(len(record)))
 }
 
-// Close the file
-func (f *File) Close() error {
-	return f.f.Close()
+// write appends already framed records to the file. It does not wait for
+// them to be durable, see durable.
+func (f *File) write(raw []byte, records int64) (offset int64, err error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.writeLocked(raw, records)
 }
 
-// Iterator callback
-type Iterator func(entry io.Reader)
+// writeLocked is write with f.m already held.
+func (f *File) writeLocked(raw []byte, records int64) (offset int64, err error) {
+	offset = f.size.Load()
+	if err = f.addFeatures(raw); err != nil {
+		return 0, err
+	}
+	if _, err = f.f.Write(raw); err == nil {
+		err = f.written(int64(len(raw)))
+	}
+	if err != nil {
+		// Do not leave behind records the caller is told were not written
+		f.f.Truncate(f.start + offset)
+		return 0, err
+	}
+	f.size.Add(int64(len(raw)))
+	f.records.Add(records)
+	f.indexWritten(offset, raw, records)
+	if f.wake != nil {
+		close(f.wake)
+		f.wake = nil
+	}
+	return offset, nil
+}
 
-// Blocks the file for reading all the content
-func (f *File) Iterate(iterator Iterator) error {
+// Truncate removes the records from offset onwards. offset must be the
+// beginning of a record, or the end of the file. It fails with FileMapped if
+// a Mapping of the file reaches past offset.
+func (f *File) Truncate(offset int64) error {
 	f.m.Lock()
 	defer f.m.Unlock()
-	// Use a section reader to increase performance
+	return f.truncateLocked(offset)
+}
+
+// truncateLocked is Truncate with f.m already held.
+func (f *File) truncateLocked(offset int64) error {
+	if offset < 0 || offset > f.Size() {
+		return OffsetOutOfRange
+	}
+	if offset < f.mapped {
+		return FileMapped
+	}
 
-	_, err := f.f.Seek(0, 0)
+	records, err := f.recordAt(offset)
 	if err != nil {
 		return err
 	}
-	defer f.f.Seek(0, 2)
+	if err := f.f.Truncate(f.start + offset); err != nil {
+		return err
+	}
+	f.size.Store(offset)
+	f.records.Store(records)
+	f.truncateIndex(offset)
+	return f.flush()
+}
 
-	var size int64
-	for {
-		err := binary.Read(f.f, binary.LittleEndian, &size)
-		if err == io.EOF {
-			return nil
+// recordAt returns the number of the record that starts at offset, or the
+// number of records if offset is the end of the file.
+func (f *File) recordAt(offset int64) (int64, error) {
+	var records, end int64
+	err := f.scan(0, 0, func(record, at int64, hdr []byte) error {
+		if at == offset {
+			records = record
+			return Stop
 		}
-		if err != nil {
-			return err
+		if at > offset {
+			return &CorruptionError{Offset: offset, Reason: "not the beginning of a record"}
 		}
-		iterator(&io.LimitedReader{R: f.f, N: size})
+		n, flags, _ := decodeHeader(hdr)
+		records, end = record+1, at+recordSize(n, flags)
+		return nil
+	})
+	if err == Stop {
+		return records, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if offset != end {
+		// In the middle of the last record
+		return 0, &CorruptionError{Offset: offset, Reason: "not the beginning of a record"}
+	}
+	return records, nil
+}
+
+// Size returns the offset where the next record will be written.
+func (f *File) Size() int64 {
+	return f.size.Load()
+}
+
+// section returns a reader of n bytes of the file from offset.
+func (f *File) section(offset, n int64) *io.SectionReader {
+	return io.NewSectionReader(f.f, f.start+offset, n)
+}
+
+// readAt reads the file at offset, counted like the offsets of records.
+func (f *File) readAt(p []byte, offset int64) (int, error) {
+	return f.f.ReadAt(p, f.start+offset)
+}
+
+// stats returns the size and the number of records of the file.
+func (f *File) stats() (size, records int64) {
+	return f.size.Load(), f.records.Load()
+}
+
+// ReadEntry returns the data of the record that starts at offset. An offset
+// that is not the beginning of a record is reported as a *CorruptionError.
+func (f *File) ReadEntry(offset int64) ([]byte, error) {
+	return f.Snapshot().ReadEntry(offset)
+}
+
+// Close the file
+func (f *File) Close() error {
+	if f.cached {
+		return f.db.release(f)
+	}
+	return f.close()
+}
+
+// close closes the file and its index.
+func (f *File) close() error {
+	if f.index != nil {
+		f.index.f.Close()
 	}
+	return f.f.Close()
+}
+
+// Iterator callback
+type Iterator func(entry io.Reader)
+
+// Iterate reads all the content of the file. It does not block writers:
+// records appended while iterating are not seen. A record that does not
+// match its checksum stops the iteration with a *CorruptionError.
+func (f *File) Iterate(iterator Iterator) error {
+	return f.Snapshot().Iterate(iterator)
+}
 
-	return err
+// IterateFrom is like Iterate but starts at the record found at offset. It
+// returns the offset following the last record read, so a later call can
+// resume from there.
+func (f *File) IterateFrom(offset int64, iterator Iterator) (next int64, err error) {
+	return f.Snapshot().IterateFrom(offset, iterator)
 }
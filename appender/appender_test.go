@@ -1,9 +1,14 @@
 package appender
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"os"
 	"testing"
 )
 
@@ -130,3 +135,284 @@ func TestSomething(t *testing.T) {
 	}
 
 }
+
+func TestTornWrite(t *testing.T) {
+	db := &DB{}
+	db.Remove("user_torn")
+	defer db.Remove("user_torn")
+
+	f, err := db.Open("user_torn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sample := []string{"hello", "world"}
+	if err = WriteAll(f, sample); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// Simulate a crash in the middle of a Write
+	raw, err := os.OpenFile("user_torn", os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.Write(encode(0, []byte("lost"))[:headerSize+2])
+	raw.Close()
+
+	_, err = db.Open("user_torn")
+	if cerr, ok := err.(*CorruptionError); !ok || cerr.Offset != 2*headerSize+10 {
+		t.Fatal("Expected a corruption error at the end of the file. Got", err)
+	}
+
+	db.Repair = true
+	f, err = db.Open("user_torn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err = WriteAll(f, []string{"again"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"hello", "world", "again"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeLegacy writes entries as the first versions of the package did.
+func writeLegacy(t *testing.T, path string, entries ...string) []byte {
+	buf := &bytes.Buffer{}
+	for _, e := range entries {
+		binary.Write(buf, binary.LittleEndian, int64(len(e)))
+		buf.WriteString(e)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLegacyNotRepaired(t *testing.T) {
+	db := &DB{Root: t.TempDir(), Repair: true}
+	path, _ := db.path("user_legacy")
+	old := writeLegacy(t, path, "hello", "world", "")
+
+	if _, err := db.OpenPathReadOnly(path); err != LegacyFormat {
+		t.Fatal("Expected LegacyFormat. Got", err)
+	}
+	if raw, _ := os.ReadFile(path); !bytes.Equal(raw, old) {
+		t.Error("The file should be left untouched. Got", raw)
+	}
+
+	// Opening it for writing migrates it
+	f, err := db.Open("user_legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"hello", "world", ""}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLegacyCutShort(t *testing.T) {
+	db := &DB{Root: t.TempDir()}
+	path, _ := db.path("user_legacy")
+	old := writeLegacy(t, path, "hello", "world", "cut")
+	old = old[:len(old)-1]
+	os.WriteFile(path, old, 0600)
+
+	if _, err := db.OpenPathReadOnly(path); err != LegacyFormat {
+		t.Fatal("Expected LegacyFormat. Got", err)
+	}
+	if _, err := db.Open("user_legacy"); err == nil {
+		t.Fatal("Expected an error without Repair")
+	}
+	if raw, _ := os.ReadFile(path); !bytes.Equal(raw, old) {
+		t.Error("The file should be left untouched. Got", raw)
+	}
+
+	db.Repair = true
+	f, err := db.Open("user_legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"hello", "world"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCorruption(t *testing.T) {
+	db := &DB{}
+	db.Remove("user_corrupt")
+	defer db.Remove("user_corrupt")
+
+	f, err := db.Open("user_corrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = WriteAll(f, []string{"hello", "world", "!"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a bit of "world"
+	raw, err := os.OpenFile("user_corrupt", os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.WriteAt([]byte{'W'}, fileHeaderSize+2*headerSize+5)
+	raw.Close()
+
+	data, err := ReadAll(f)
+	if cerr, ok := err.(*CorruptionError); !ok || cerr.Offset != headerSize+5 {
+		t.Fatal("Expected a corruption error for the second record. Got", err)
+	}
+	if err = Compare(data, []string{"hello"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOffsets(t *testing.T) {
+	db := &DB{}
+	db.Remove("user_offsets")
+	defer db.Remove("user_offsets")
+
+	f, err := db.Open("user_offsets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	sample := []string{"hello", "world", "el", "mundo"}
+	offsets := []int64{}
+	for _, s := range sample {
+		offset, err := f.Append([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, offset)
+	}
+
+	for i, offset := range offsets {
+		data, err := f.ReadEntry(offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != sample[i] {
+			t.Error("Expected", sample[i], "at", offset, "Got", string(data))
+		}
+	}
+
+	if _, err = f.ReadEntry(f.Size()); err != OffsetOutOfRange {
+		t.Error("Expected OffsetOutOfRange. Got", err)
+	}
+	if _, err = f.ReadEntry(offsets[1] + 1); err == nil {
+		t.Error("Expected an error reading from the middle of a record")
+	}
+
+	dataRead := []string{}
+	next, err := f.IterateFrom(offsets[2], func(entry io.Reader) {
+		readed, _ := ioutil.ReadAll(entry)
+		dataRead = append(dataRead, string(readed))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(dataRead, sample[2:]); err != nil {
+		t.Fatal(err)
+	}
+	if next != f.Size() {
+		t.Error("Expected to stop at", f.Size(), "Got", next)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	db := &DB{IndexInterval: 1}
+	db.Remove("user_truncate")
+	defer db.Remove("user_truncate")
+
+	f, err := db.Open("user_truncate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	WriteAll(f, []string{"hello", "world"})
+	offset, _ := f.Append([]byte("el"))
+	f.Append([]byte("mundo"))
+
+	if err = f.Truncate(offset + 1); err == nil {
+		t.Error("Expected an error truncating in the middle of a record")
+	}
+	if err = f.Truncate(offset); err != nil {
+		t.Fatal(err)
+	}
+	if size, records := f.stats(); size != offset || records != 2 || len(f.index.entries) != 2 {
+		t.Error("Unexpected size", size, "records", records, "index", f.index.entries)
+	}
+	WriteAll(f, []string{"!"})
+	data, err := ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"hello", "world", "!"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxRecordSize(t *testing.T) {
+	db := &DB{MaxRecordSize: 10}
+	db.Remove("user_max_size")
+	defer db.Remove("user_max_size")
+
+	f, err := db.Open("user_max_size")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.Append(make([]byte, 11)); err != RecordTooLarge {
+		t.Error("Expected RecordTooLarge. Got", err)
+	}
+	// Each entry of a batch takes 8 bytes more for its length
+	if _, err = f.WriteBatch([][]byte{make([]byte, 3)}); err != RecordTooLarge {
+		t.Error("Expected RecordTooLarge for a batch. Got", err)
+	}
+	if _, err = f.WriteBatch([][]byte{make([]byte, 2)}); err != nil {
+		t.Error("Expected a batch of 10 bytes to fit. Got", err)
+	}
+	f.Append(make([]byte, 10))
+
+	// A record written with a larger limit, or a damaged length
+	db.MaxRecordSize = 1 << 20
+	offset, _ := f.Append(make([]byte, 1<<20))
+	db.MaxRecordSize = 10
+	_, err = ReadAll(f)
+	if terr, ok := err.(*RecordTooLargeError); !ok || terr.Offset != offset || terr.Max != 10 {
+		t.Fatal("Expected a *RecordTooLargeError. Got", err)
+	}
+	if !errors.Is(err, RecordTooLarge) {
+		t.Error("Expected the error to be RecordTooLarge")
+	}
+	if _, err = f.ReadEntry(offset); !errors.Is(err, RecordTooLarge) {
+		t.Error("Expected RecordTooLarge from ReadEntry. Got", err)
+	}
+
+	// No limit
+	db.MaxRecordSize = math.MaxInt64
+	if _, err = ReadAll(f); err != nil {
+		t.Error("Expected no limit. Got", err)
+	}
+}
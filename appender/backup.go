@@ -0,0 +1,341 @@
+package appender
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// backupFile is a file to be saved by a backup.
+type backupFile struct {
+	rel    string // Path inside Root, with forward slashes
+	path   string
+	size   int64 // Bytes to save, the file header included
+	sealed bool  // Never written again, so it can be linked
+}
+
+// snapshot lists the files of the database with the size they have at this
+// very moment. Writers are stopped while the sizes of the open files are
+// taken, and no file nor log is opened until the closed ones are sized too,
+// so all files are saved at the same point in time. The segments of open logs are kept
+// until release is called, even if the retention policy drops them.
+func (db *DB) snapshot() (files []backupFile, release func(), err error) {
+	sizes := map[string]int64{}
+	active := map[string]bool{} // Segments being written
+	var logs []*Log
+	var held [][]*segment
+
+	// Do not catch a transaction half way
+	db.txm.Lock()
+	defer db.txm.Unlock()
+	db.om.Lock()
+	defer db.om.Unlock()
+
+	db.m.Lock()
+	var locked []*File
+	for _, f := range db.files {
+		f.m.Lock()
+		locked = append(locked, f)
+	}
+	for l := range db.logs {
+		l.m.Lock()
+		for _, s := range l.segs {
+			s.refs++
+		}
+		logs = append(logs, l)
+		held = append(held, append([]*segment(nil), l.segs...))
+		if len(l.segs) > 0 {
+			s := l.segs[len(l.segs)-1]
+			s.m.Lock()
+			locked = append(locked, s.File)
+			active[s.name] = true
+		}
+	}
+	for _, f := range locked {
+		sizes[f.name] = f.start + f.Size()
+	}
+	for _, l := range held {
+		for _, s := range l {
+			sizes[s.name] = s.start + s.Size()
+		}
+	}
+	for _, f := range locked {
+		f.m.Unlock()
+	}
+	for _, l := range logs {
+		l.m.Unlock()
+	}
+	db.m.Unlock()
+
+	release = func() {
+		for i, l := range logs {
+			l.release(held[i])
+		}
+	}
+	openLogs := map[string]bool{}
+	for _, l := range logs {
+		openLogs[l.dir] = true
+	}
+
+	root := db.Root
+	if root == "" {
+		root = "."
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		path := filepath.Join(db.Root, e.Name())
+		if !e.IsDir() {
+			if !backedUp(e.Name()) {
+				continue
+			}
+			f, err := db.backupFile(e.Name(), path, sizes, false)
+			if err != nil {
+				release()
+				return nil, nil, err
+			}
+			files = append(files, f)
+			continue
+		}
+
+		segs, err := filepath.Glob(filepath.Join(path, "*"+segmentExt))
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		for i, s := range segs {
+			_, seen := sizes[s]
+			if openLogs[path] && !seen {
+				// Started after the snapshot
+				continue
+			}
+			// The last segment of a log may be written again, even if
+			// the log is closed, so it is never linked.
+			rel := e.Name() + "/" + filepath.Base(s)
+			f, err := db.backupFile(rel, s, sizes, i < len(segs)-1 && !active[s])
+			if err != nil {
+				release()
+				return nil, nil, err
+			}
+			files = append(files, f)
+		}
+	}
+	return files, release, nil
+}
+
+// backedUp tells if a file in Root holds data. Names given to Open never
+// hold a dot, the files kept next to them do.
+func backedUp(name string) bool {
+	return !strings.Contains(name, ".")
+}
+
+func (db *DB) backupFile(rel, path string, sizes map[string]int64, sealed bool) (backupFile, error) {
+	size, ok := sizes[path]
+	if !ok {
+		info, err := os.Stat(path)
+		if err != nil {
+			return backupFile{}, err
+		}
+		size = info.Size()
+	}
+	return backupFile{rel: rel, path: path, size: size, sealed: sealed}, nil
+}
+
+// Backup saves a consistent copy of every file and log of the database into
+// dir, without stopping writers for more than an instant. Segments of logs
+// that are no longer written are hard linked when possible; everything else
+// is copied up to the size it had when the backup started. dir must not
+// exist or be empty. Use Restore to bring the copy back.
+func (db *DB) Backup(dir string) error {
+	if err := emptyDir(dir); err != nil {
+		return err
+	}
+	// The journal is not saved, so a transaction left half way is
+	// finished first
+	if err := db.recoverJournal(); err != nil {
+		return err
+	}
+	db.cm.RLock()
+	defer db.cm.RUnlock()
+	files, release, err := db.snapshot()
+	if err != nil {
+		return err
+	}
+	defer release()
+	return db.copyBackup(dir, files)
+}
+
+func (db *DB) copyBackup(dir string, files []backupFile) error {
+	for _, f := range files {
+		target := filepath.Join(dir, filepath.FromSlash(f.rel))
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return err
+		}
+		if f.sealed && os.Link(f.path, target) == nil {
+			continue
+		}
+		if err := copyFile(target, f.path, f.size); err != nil {
+			return err
+		}
+	}
+	return syncDir(dir)
+}
+
+// BackupTar is like Backup but writes the copy as a tar stream to w.
+func (db *DB) BackupTar(w io.Writer) error {
+	// The journal is not saved, so a transaction left half way is
+	// finished first
+	if err := db.recoverJournal(); err != nil {
+		return err
+	}
+	db.cm.RLock()
+	defer db.cm.RUnlock()
+	files, release, err := db.snapshot()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	tw := tar.NewWriter(w)
+	dirs := map[string]bool{}
+	now := time.Now()
+	for _, f := range files {
+		if dir, _, ok := strings.Cut(f.rel, "/"); ok && !dirs[dir] {
+			dirs[dir] = true
+			hdr := &tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0700, ModTime: now}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: f.rel, Size: f.size, Mode: 0600, ModTime: now}); err != nil {
+			return err
+		}
+		src, err := os.Open(f.path)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(tw, src, f.size)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// Restore copies a backup made with Backup into root, which must not exist
+// or be empty. The database must not be open.
+func Restore(dir, root string) error {
+	if err := emptyDir(root); err != nil {
+		return err
+	}
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(root, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return copyFile(target, path, info.Size())
+	})
+	if err != nil {
+		return err
+	}
+	return syncDir(root)
+}
+
+// RestoreTar is like Restore for a backup made with BackupTar.
+func RestoreTar(r io.Reader, root string) error {
+	if err := emptyDir(root); err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.FromSlash(strings.TrimSuffix(hdr.Name, "/"))
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("appender: invalid path %q in backup", hdr.Name)
+		}
+		target := filepath.Join(root, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("appender: unexpected entry %q in backup", hdr.Name)
+		}
+	}
+	return syncDir(root)
+}
+
+// emptyDir creates dir, or makes sure it is empty if it exists.
+func emptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return os.MkdirAll(dir, 0700)
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return errors.New("appender: " + dir + " is not empty")
+	}
+	return nil
+}
+
+// copyFile copies the first size bytes of src to a new file dst.
+func copyFile(dst, src string, size int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFile(dst, io.LimitReader(in, size))
+}
+
+// writeFile writes everything read from r into a new file.
+func writeFile(name string, r io.Reader) error {
+	out, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
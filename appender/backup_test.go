@@ -0,0 +1,145 @@
+package appender
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func backupDB(t *testing.T) (*DB, *File, *Log) {
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SegmentSize = 2 * (headerSize + 5)
+	db.IndexInterval = 1
+
+	f, err := db.Open("user_backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	WriteAll(f, []string{"hello", "world"})
+	l, err := db.OpenLog("log_backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	WriteAllLog(l, []string{"00000", "11111", "22222"})
+	return db, f, l
+}
+
+func checkRestored(t *testing.T, root string) {
+	db := &DB{Root: root}
+	f, err := db.Open("user_backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"hello", "world"}); err != nil {
+		t.Error(err)
+	}
+
+	l, err := db.OpenLog("log_backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if data, err = ReadAllLog(l); err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"00000", "11111", "22222"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	db, f, l := backupDB(t)
+	defer f.Close()
+	defer l.Close()
+
+	dir := filepath.Join(t.TempDir(), "backup")
+	files, release, err := db.snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes after the snapshot are not saved
+	f.Write([]byte("later"))
+	l.Write([]byte("33333"))
+	l.Write([]byte("44444"))
+	l.EnforceRetention()
+
+	if err = db.copyBackup(dir, files); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	first := filepath.Join("log_backup", segmentName(0))
+	a, _ := os.Stat(filepath.Join(db.Root, first))
+	b, _ := os.Stat(filepath.Join(dir, first))
+	if a == nil || b == nil || !os.SameFile(a, b) {
+		t.Error("Expected sealed segments to be linked")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "user_backup"+indexExt)); !os.IsNotExist(err) {
+		t.Error("Expected the index not to be saved")
+	}
+
+	root := filepath.Join(t.TempDir(), "restored")
+	if err = Restore(dir, root); err != nil {
+		t.Fatal(err)
+	}
+	checkRestored(t, root)
+
+	if err = db.Backup(dir); err == nil {
+		t.Error("Expected an error backing up to a directory that is not empty")
+	}
+}
+
+func TestBackupTar(t *testing.T) {
+	db, f, l := backupDB(t)
+	defer f.Close()
+	defer l.Close()
+
+	var buf bytes.Buffer
+	if err := db.BackupTar(&buf); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("later"))
+
+	root := filepath.Join(t.TempDir(), "restored")
+	if err := RestoreTar(bytes.NewReader(buf.Bytes()), root); err != nil {
+		t.Fatal(err)
+	}
+	checkRestored(t, root)
+}
+
+func TestBackupJournal(t *testing.T) {
+	db := crashTx(t, true)
+	var buf bytes.Buffer
+	if err := db.BackupTar(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(t.TempDir(), "restored")
+	if err := RestoreTar(bytes.NewReader(buf.Bytes()), root); err != nil {
+		t.Fatal(err)
+	}
+	restored := &DB{Root: root}
+	for name, want := range map[string][]string{"user_sender": {"hello", "sent 10"}, "user_recipient": {"received 10"}} {
+		f, err := restored.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = Compare(data, want); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package appender
+
+import (
+	"encoding/binary"
+)
+
+// WriteBatch appends all the entries as a single record, so after a crash
+// either all of them or none are found. Iterate gives back each entry on its
+// own. It returns the offset of the record, which holds the whole batch.
+func (f *File) WriteBatch(entries [][]byte) (offset int64, err error) {
+	record, err := f.db.frame(flagBatch, nil, encodeBatch(entries))
+	if err != nil {
+		return 0, err
+	}
+	offset, err = f.write(record, 1)
+	if err != nil {
+		return 0, err
+	}
+	return offset, f.durable(offset + int64(len(record)))
+}
+
+// ReadBatch returns the entries of the record that starts at offset.
+func (f *File) ReadBatch(offset int64) ([][]byte, error) {
+	return f.Snapshot().ReadBatch(offset)
+}
+
+// encodeBatch writes every entry preceded by its int64 little endian length.
+func encodeBatch(list [][]byte) []byte {
+	size := 0
+	for _, e := range list {
+		size += 8 + len(e)
+	}
+	buf := make([]byte, 0, size)
+	for _, e := range list {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(len(e)))
+		buf = append(buf, e...)
+	}
+	return buf
+}
+
+// entries returns the entries stored in the data of the record at offset.
+func entries(offset int64, flags byte, data []byte) ([][]byte, error) {
+	if flags&flagCheckpoint != 0 {
+		// Its data belongs to the checkpoint, see LastCheckpoint
+		return nil, nil
+	}
+	if flags&flagPadding != 0 {
+		return nil, nil
+	}
+	if flags&flagBatch == 0 {
+		return [][]byte{data}, nil
+	}
+	list := [][]byte{}
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, &CorruptionError{Offset: offset, Reason: "malformed batch"}
+		}
+		n := binary.LittleEndian.Uint64(data)
+		if n > uint64(len(data)-8) {
+			return nil, &CorruptionError{Offset: offset, Reason: "malformed batch"}
+		}
+		list = append(list, data[8:8+n])
+		data = data[8+n:]
+	}
+	return list, nil
+}
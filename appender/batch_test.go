@@ -0,0 +1,97 @@
+package appender
+
+import (
+	"os"
+	"testing"
+)
+
+func TestWriteBatch(t *testing.T) {
+	db := &DB{Repair: true}
+	db.Remove("user_batch")
+	defer db.Remove("user_batch")
+
+	f, err := db.Open("user_batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("hello"))
+	offset, err := f.WriteBatch([][]byte{[]byte("el"), []byte(""), []byte("mundo")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("!"))
+
+	data, err := ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"hello", "el", "", "mundo", "!"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = f.ReadEntry(offset); err != BatchRecord {
+		t.Error("Expected BatchRecord. Got", err)
+	}
+	batch, err := f.ReadBatch(offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 3 || string(batch[2]) != "mundo" {
+		t.Error("Unexpected batch", batch)
+	}
+
+	// Crash in the middle of writing a batch
+	size := f.Size()
+	f.WriteBatch([][]byte{[]byte("all"), []byte("or"), []byte("nothing")})
+	f.Close()
+	if err = os.Truncate("user_batch", fileHeaderSize+size+headerSize+15); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = db.Open("user_batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err = ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"hello", "el", "", "mundo", "!"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkWriteLoop(b *testing.B) {
+	benchmarkBatch(b, func(f *File, entries [][]byte) {
+		for _, e := range entries {
+			f.Write(e)
+		}
+	})
+}
+
+func BenchmarkWriteBatch(b *testing.B) {
+	benchmarkBatch(b, func(f *File, entries [][]byte) {
+		f.WriteBatch(entries)
+	})
+}
+
+func benchmarkBatch(b *testing.B, write func(*File, [][]byte)) {
+	db := &DB{}
+	db.Remove("user_bench_batch")
+	defer db.Remove("user_bench_batch")
+	f, err := db.Open("user_bench_batch")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+
+	entries := make([][]byte, 16)
+	for i := range entries {
+		entries[i] = make([]byte, 64)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		write(f, entries)
+	}
+}
@@ -0,0 +1,92 @@
+package appender
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// path returns where the file or log called name is stored.
+func (db *DB) path(name string) (string, error) {
+	if name == "" {
+		return "", InvalidName
+	}
+	return filepath.Join(db.Root, escape(name)), nil
+}
+
+// escape turns a name into a safe file name. Everything but letters, digits,
+// '-' and '_' is written as %XX, so names can not leave the root directory
+// nor clash with the files the package keeps next to them.
+func escape(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// release is called when a file returned by Open is closed. Once nobody uses
+// it, it is kept open in the idle list if MaxOpenFiles allows it.
+func (db *DB) release(f *File) error {
+	db.m.Lock()
+	defer db.m.Unlock()
+	if f.refs == 0 {
+		return nil
+	}
+	f.refs--
+	if f.refs > 0 {
+		return nil
+	}
+	if db.MaxOpenFiles > 0 && db.files[f.name] == f {
+		f.idle = db.idle.PushFront(f)
+		return nil
+	}
+	db.forget(f)
+	return f.close()
+}
+
+// evict closes the least recently used idle file. It returns false if every
+// open file is in use. db.m must be held.
+func (db *DB) evict() bool {
+	if db.idle == nil || db.idle.Len() == 0 {
+		return false
+	}
+	f := db.idle.Back().Value.(*File)
+	db.forget(f)
+	f.close()
+	return true
+}
+
+// forget takes f out of the cache, so the next Open of its name opens the
+// file again. db.m must be held.
+func (db *DB) forget(f *File) {
+	if db.files[f.name] == f {
+		delete(db.files, f.name)
+	}
+	if f.idle != nil {
+		db.idle.Remove(f.idle)
+		f.idle = nil
+	}
+}
+
+// Close closes the files kept open by the database and stops the janitor.
+// Files and logs still in use must be closed by their users.
+func (db *DB) Close() error {
+	db.StopJanitor()
+	db.m.Lock()
+	defer db.m.Unlock()
+	var err error
+	for db.idle != nil && db.idle.Len() > 0 {
+		f := db.idle.Back().Value.(*File)
+		db.forget(f)
+		if cerr := f.close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package appender
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestRoot(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "db")
+	db, err := NewDB(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, name := range []string{"user123", "../evil", "/etc/passwd", ".hidden", "a b"} {
+		f, err := db.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Dir(f.name) != root {
+			t.Error(name, "stored out of the root:", f.name)
+		}
+		f.Close()
+	}
+	if _, err := os.Stat(filepath.Join(root, "user123")); err != nil {
+		t.Error("Plain names should not be escaped", err)
+	}
+	if _, err := db.Open(""); err != InvalidName {
+		t.Error("Expected InvalidName. Got", err)
+	}
+}
+
+func TestSharedFiles(t *testing.T) {
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	files := make([]*File, 10)
+	var wg sync.WaitGroup
+	for i := range files {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			files[i], _ = db.Open("user123")
+		}(i)
+	}
+	wg.Wait()
+	for _, f := range files {
+		if f != files[0] {
+			t.Fatal("Expected the same *File for every Open")
+		}
+	}
+
+	for _, f := range files[1:] {
+		f.Close()
+	}
+	if _, err := files[0].Write([]byte("still open")); err != nil {
+		t.Fatal(err)
+	}
+	files[0].Close()
+	if _, err := files[0].Write([]byte("closed")); err == nil {
+		t.Fatal("The file should be closed")
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.MaxOpenFiles = 2
+	defer db.Close()
+
+	a, _ := db.Open("a")
+	b, _ := db.Open("b")
+	if _, err := db.Open("c"); err != TooManyOpenFiles {
+		t.Fatal("Expected TooManyOpenFiles. Got", err)
+	}
+
+	a.Close()
+	b.Close()
+	if again, _ := db.Open("a"); again != a {
+		t.Fatal("Expected the cached file")
+	}
+	a.Close()
+
+	// b is the least recently used
+	c, err := db.Open("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, ok := db.files[b.name]; ok {
+		t.Error("Expected b to be evicted")
+	}
+	if _, ok := db.files[a.name]; !ok {
+		t.Error("Expected a to be cached")
+	}
+	if _, err := b.Write([]byte("evicted")); err == nil {
+		t.Error("Evicted files should be closed")
+	}
+}
@@ -0,0 +1,197 @@
+package appender
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A checkpoint is a record marking the point up to which the records of a
+// file were applied to a snapshot of some state, so a file can be used as a
+// write-ahead log. It holds no entries, so walks skip it, but it carries data
+// of its own, like the name of the snapshot. After a restart the state is
+// loaded from the snapshot and only the records following the last
+// checkpoint are applied again. The records before it can be dropped from a
+// Log with TruncateBefore.
+type Checkpoint struct {
+	Offset int64 // Where the checkpoint starts
+	Next   int64 // Where the records following the checkpoint start
+	Time   time.Time
+	Data   []byte
+}
+
+// rewriteExt is added to the name of a segment being written by
+// TruncateBefore or Compact, until it is complete.
+const rewriteExt = ".rewrite"
+
+// Checkpoint appends a checkpoint holding data and returns its offset.
+func (f *File) Checkpoint(data []byte) (offset int64, err error) {
+	record, err := f.db.frame(flagCheckpoint, &Meta{}, data)
+	if err != nil {
+		return 0, err
+	}
+	offset, err = f.write(record, 1)
+	if err != nil {
+		return 0, err
+	}
+	return offset, f.durable(offset + int64(len(record)))
+}
+
+// Checkpoint appends a checkpoint holding data. See File.Checkpoint.
+func (l *Log) Checkpoint(data []byte) (offset int64, err error) {
+	record, err := l.db.frame(flagCheckpoint, &Meta{}, data)
+	if err != nil {
+		return 0, err
+	}
+	return l.append(record)
+}
+
+// LastCheckpoint returns the last checkpoint of the snapshot, or NoCheckpoint
+// if there is none. Only the headers of the other records are read.
+func (r *Reader) LastCheckpoint() (Checkpoint, error) {
+	last := int64(-1)
+	err := r.f.scan(0, 0, func(record, offset int64, hdr []byte) error {
+		if offset >= r.size {
+			return Stop
+		}
+		if _, flags, _ := decodeHeader(hdr); flags&flagCheckpoint != 0 {
+			last = offset
+		}
+		return nil
+	})
+	if err != nil && err != Stop {
+		return Checkpoint{}, err
+	}
+	if last < 0 {
+		return Checkpoint{}, NoCheckpoint
+	}
+
+	remaining := r.size - last
+	data, flags, err := readRecord(r.f.section(last, remaining), last, remaining, r.f.db.maxRecordSize())
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	meta, body, err := splitMeta(last, flags, data)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	if body, err = r.f.db.unseal(last, flags, data[:len(data)-len(body)], body); err != nil {
+		return Checkpoint{}, err
+	}
+	return Checkpoint{
+		Offset: last,
+		Next:   last + recordSize(int64(len(data)), flags),
+		Time:   meta.Time,
+		Data:   body,
+	}, nil
+}
+
+// LastCheckpoint returns the last checkpoint of the file. See
+// Reader.LastCheckpoint.
+func (f *File) LastCheckpoint() (Checkpoint, error) {
+	return f.Snapshot().LastCheckpoint()
+}
+
+// LastCheckpoint returns the last checkpoint kept in the log. See
+// Reader.LastCheckpoint.
+func (l *Log) LastCheckpoint() (Checkpoint, error) {
+	segs := l.acquire()
+	defer l.release(segs)
+	for i := len(segs) - 1; i >= 0; i-- {
+		c, err := segs[i].Snapshot().LastCheckpoint()
+		if err == NoCheckpoint {
+			continue
+		}
+		if err != nil {
+			return c, err
+		}
+		c.Offset += segs[i].base
+		c.Next += segs[i].base
+		return c, nil
+	}
+	return Checkpoint{}, NoCheckpoint
+}
+
+// TruncateBefore drops the records of the log before offset, which must be
+// the beginning of a record or the end of the log, like the Next of a
+// Checkpoint. The records kept do not change their offsets.
+//
+// Segments that end before offset are dropped, and the one holding offset is
+// copied without the records that precede it to a new segment that replaces
+// it. As with the retention policy, the files being read are only removed
+// once the readers are done.
+func (l *Log) TruncateBefore(offset int64) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	last := l.segs[len(l.segs)-1]
+	if offset < l.segs[0].base || offset > last.base+last.Size() {
+		return OffsetOutOfRange
+	}
+
+	for len(l.segs) > 1 && (l.segs[1].base <= offset || l.segs[0].base+l.segs[0].Size() <= offset) {
+		l.drop()
+	}
+	s := l.segs[0]
+	if offset <= s.base {
+		// It was the end of a segment followed by a gap
+		return nil
+	}
+	rewritten, err := l.rewrite(s, offset)
+	if err != nil {
+		return err
+	}
+	l.segs[0] = rewritten
+	s.doom()
+	return nil
+}
+
+// rewrite copies the records of s from offset onwards to a new segment that
+// starts at offset. l.m must be held, so nothing is written to s meanwhile.
+//
+// The new segment is renamed into place once complete. If the process stops
+// before s is removed, OpenLog finds both and removes s.
+func (l *Log) rewrite(s *segment, offset int64) (*segment, error) {
+	local := offset - s.base
+	if _, err := s.recordAt(local); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(l.dir, segmentName(offset))
+	tmp := path + rewriteExt
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+	_, err = out.Write(encodeFileHeader(s.features))
+	if err == nil {
+		_, err = io.Copy(out, s.section(local, s.Size()-local))
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = s.keepAge(tmp)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	if err := syncDir(l.dir); err != nil {
+		return nil, err
+	}
+	// Writers waiting for s to be flushed find their records in the copy
+	s.markSynced(s.Size())
+
+	f, err := l.db.openFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &segment{File: f, base: offset, created: s.created}, nil
+}
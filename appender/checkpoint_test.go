@@ -0,0 +1,151 @@
+package appender
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := db.Open("user_wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err = f.LastCheckpoint(); err != NoCheckpoint {
+		t.Fatal("Expected NoCheckpoint. Got", err)
+	}
+	WriteAll(f, []string{"a", "b"})
+	offset, err := f.Checkpoint([]byte("snapshot 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := f.Size()
+	WriteAll(f, []string{"c"})
+
+	data, err := ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"a", "b", "c"}); err != nil {
+		t.Error(err)
+	}
+	if _, err = f.ReadEntry(offset); err != CheckpointRecord {
+		t.Error("Expected CheckpointRecord. Got", err)
+	}
+
+	c, err := f.LastCheckpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Offset != offset || c.Next != next || string(c.Data) != "snapshot 1" || c.Time.IsZero() {
+		t.Error("Unexpected checkpoint", c)
+	}
+}
+
+func TestTruncateBefore(t *testing.T) {
+	root := t.TempDir()
+	db := &DB{Root: root, SegmentSize: 3 * (headerSize + 5)}
+	l, err := db.OpenLog("log_wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	WriteAllLog(l, []string{"00000", "11111", "22222", "33333"})
+	if _, err = l.Checkpoint([]byte("snap")); err != nil {
+		t.Fatal(err)
+	}
+	WriteAllLog(l, []string{"44444", "55555"})
+	c, err := l.LastCheckpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(c.Data) != "snap" {
+		t.Fatal("Expected snap. Got", string(c.Data))
+	}
+
+	if err = l.TruncateBefore(c.Next + 1); err == nil {
+		t.Error("Expected an error truncating in the middle of a record")
+	}
+	if err = l.TruncateBefore(c.Next); err != nil {
+		t.Fatal(err)
+	}
+	if l.First() != c.Next {
+		t.Error("Expected the log to start at", c.Next, "Got", l.First())
+	}
+	data, err := ReadAllLog(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"44444", "55555"}); err != nil {
+		t.Error(err)
+	}
+	if entry, err := l.ReadEntry(c.Next); err != nil || string(entry) != "44444" {
+		t.Error("Expected offsets to be kept. Got", string(entry), err)
+	}
+	if err = l.TruncateBefore(0); err != OffsetOutOfRange {
+		t.Error("Expected OffsetOutOfRange. Got", err)
+	}
+
+	// Keep writing and find everything after reopening
+	WriteAllLog(l, []string{"66666"})
+	l.Close()
+	if l, err = db.OpenLog("log_wal"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = ReadAllLog(l); err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"44444", "55555", "66666"}); err != nil {
+		t.Error(err)
+	}
+	if _, err = l.LastCheckpoint(); err != NoCheckpoint {
+		t.Error("Expected NoCheckpoint. Got", err)
+	}
+}
+
+func TestTruncateBeforeCrash(t *testing.T) {
+	root := t.TempDir()
+	db := &DB{Root: root}
+	l, err := db.OpenLog("log_wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	WriteAllLog(l, []string{"00000", "11111", "22222"})
+	first := filepath.Join(root, "log_wal", segmentName(0))
+	old, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := int64(headerSize + 5)
+	if err = l.TruncateBefore(offset); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	// Stopped before removing the old segment
+	os.WriteFile(first, old, 0600)
+	if l, err = db.OpenLog("log_wal"); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.First() != offset {
+		t.Error("Expected the log to start at", offset, "Got", l.First())
+	}
+	data, err := ReadAllLog(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"11111", "22222"}); err != nil {
+		t.Error(err)
+	}
+	if _, err = os.Stat(first); !os.IsNotExist(err) {
+		t.Error("Expected the old segment to be removed")
+	}
+}
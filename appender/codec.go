@@ -0,0 +1,155 @@
+package appender
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// Codec compresses the data of the records. The ID of the codec is stored
+// with every compressed record, so a file can be read as long as the codecs
+// it was written with are registered.
+type Codec interface {
+	ID() byte
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	// Flate and Gzip are the codecs available by default.
+	Flate Codec = flateCodec{}
+	Gzip  Codec = gzipCodec{}
+
+	codecsMu sync.RWMutex
+	codecs   = map[byte]Codec{}
+)
+
+func init() {
+	RegisterCodec(Flate)
+	RegisterCodec(Gzip)
+}
+
+// RegisterCodec makes a codec available to read records. It panics if the ID
+// is zero or already taken by another codec.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if c.ID() == 0 {
+		panic("appender: codec ID 0 is reserved")
+	}
+	if old, ok := codecs[c.ID()]; ok && old != c {
+		panic(fmt.Sprintf("appender: codec ID %d already registered", c.ID()))
+	}
+	codecs[c.ID()] = c
+}
+
+func codec(id byte) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	return codecs[id]
+}
+
+// compress returns the data compressed with c preceded by the codec ID, or
+// nil if compressing does not make it smaller.
+func compress(c Codec, data []byte) ([]byte, error) {
+	compressed, err := c.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed)+1 >= len(data) {
+		return nil, nil
+	}
+	return append([]byte{c.ID()}, compressed...), nil
+}
+
+// decompress returns the data of a compressed record, failing if it holds
+// more than max bytes.
+func decompress(offset int64, data []byte, max int64) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, &CorruptionError{Offset: offset, Reason: "missing codec"}
+	}
+	c := codec(data[0])
+	if c == nil {
+		return nil, &CorruptionError{Offset: offset, Reason: fmt.Sprintf("unknown codec %d", data[0])}
+	}
+	var out []byte
+	var err error
+	if l, ok := c.(limitedCodec); ok {
+		out, err = l.decompressLimit(data[1:], max)
+	} else {
+		out, err = c.Decompress(data[1:])
+	}
+	if err != nil {
+		return nil, &CorruptionError{Offset: offset, Reason: "decompressing: " + err.Error()}
+	}
+	if int64(len(out)) > max {
+		return nil, &RecordTooLargeError{Offset: offset, Size: int64(len(out)), Max: max}
+	}
+	return out, nil
+}
+
+// limitedCodec is implemented by codecs that can stop decompressing once
+// the data grows past a limit, so a tiny record can not expand into gigabytes.
+// They return at most max+1 bytes.
+type limitedCodec interface {
+	decompressLimit(data []byte, max int64) ([]byte, error)
+}
+
+type flateCodec struct{}
+
+func (flateCodec) ID() byte { return 1 }
+
+func (flateCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	return finish(&buf, w, data)
+}
+
+func (c flateCodec) Decompress(data []byte) ([]byte, error) {
+	return c.decompressLimit(data, math.MaxInt64-1)
+}
+
+func (flateCodec) decompressLimit(data []byte, max int64) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return io.ReadAll(io.LimitReader(r, max+1))
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) ID() byte { return 2 }
+
+func (gzipCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	return finish(&buf, gzip.NewWriter(&buf), data)
+}
+
+func (c gzipCodec) Decompress(data []byte) ([]byte, error) {
+	return c.decompressLimit(data, math.MaxInt64-1)
+}
+
+func (gzipCodec) decompressLimit(data []byte, max int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(io.LimitReader(r, max+1))
+}
+
+func finish(buf *bytes.Buffer, w io.WriteCloser, data []byte) ([]byte, error) {
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,110 @@
+package appender
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// halfCodec throws away half of the data. It is only good to check how
+// codecs get registered.
+type halfCodec struct{}
+
+func (halfCodec) ID() byte { return 42 }
+
+func (halfCodec) Compress(data []byte) ([]byte, error) {
+	return data[:len(data)/2], nil
+}
+
+func (halfCodec) Decompress(data []byte) ([]byte, error) {
+	return append(data, data...), nil
+}
+
+func TestCompression(t *testing.T) {
+	db := &DB{}
+	db.Remove("user_codec")
+	defer db.Remove("user_codec")
+
+	f, err := db.Open("user_codec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	json := strings.Repeat(`{"name":"guillermo","city":"madrid"}`, 100)
+	f.Write([]byte(json))
+	plain := f.Size()
+
+	db.Codec = Flate
+	f.Write([]byte(json))
+	if f.Size()-plain >= plain/2 {
+		t.Error("Expected the record to be compressed", f.Size()-plain, plain)
+	}
+	f.Write([]byte("tiny"))
+
+	db.Codec = Gzip
+	f.WriteBatch([][]byte{[]byte(json), []byte(json)})
+
+	db.Codec = nil
+	data, err := ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{json, json, "tiny", json, json}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnknownCodec(t *testing.T) {
+	db := &DB{}
+	db.Remove("user_codec_unknown")
+	defer db.Remove("user_codec_unknown")
+
+	f, err := db.Open("user_codec_unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Written by a codec that is not registered
+	data, _ := compress(halfCodec{}, bytes.Repeat([]byte("a"), 10))
+	f.write(encode(flagCompressed, data), 1)
+
+	_, err = ReadAll(f)
+	if _, ok := err.(*CorruptionError); !ok {
+		t.Fatal("Expected a corruption error. Got", err)
+	}
+
+	RegisterCodec(halfCodec{})
+	t.Cleanup(func() {
+		codecsMu.Lock()
+		defer codecsMu.Unlock()
+		delete(codecs, halfCodec{}.ID())
+	})
+	if _, err = ReadAll(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecompressionLimit(t *testing.T) {
+	db := &DB{Codec: Flate}
+	db.Remove("user_codec_bomb")
+	defer db.Remove("user_codec_bomb")
+
+	f, err := db.Open("user_codec_bomb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	offset, _ := f.Append(make([]byte, 1<<20))
+	if f.Size() > 4096 {
+		t.Fatal("Expected the record to be compressed. Got", f.Size())
+	}
+
+	// A small record that expands past the limit is not decompressed
+	db.MaxRecordSize = 1 << 16
+	_, err = f.ReadEntry(offset)
+	if terr, ok := err.(*RecordTooLargeError); !ok || terr.Size != 1<<16+1 {
+		t.Fatal("Expected a *RecordTooLargeError. Got", err)
+	}
+}
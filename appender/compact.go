@@ -0,0 +1,364 @@
+package appender
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Compaction keeps only the last record of every key of a log, the key being
+// the one in the Meta of the records. Records without a key are always kept.
+// Only the segments that are no longer written are compacted, and the
+// records kept never change their offsets: the records dropped are cut out
+// of the segment when they leave a long gap, splitting it, and otherwise
+// overwritten by padding records of the same size, which readers skip.
+//
+// A tombstone is a record marking its key as deleted, see AppendTombstone.
+// Once it is older than DB.TombstoneGrace it is dropped too. Records with a
+// key and no data are not tombstones.
+//
+// A gap is cut out when it takes at least 1/compactGap of the segment, so a
+// segment is never split in more than compactGap.
+const compactGap = 4
+
+// compactExt is added to the name of the segments written by Compact until
+// the segment they replace is gone, see Log.replace. They also end in
+// rewriteExt until they are complete.
+const compactExt = ".compact"
+
+// AppendTombstone appends a tombstone for key: compacting the log drops the
+// records of key, and eventually the tombstone itself.
+func (l *Log) AppendTombstone(key []byte) (offset int64, err error) {
+	record, err := l.db.frame(flagTombstone, &Meta{Key: key}, nil)
+	if err != nil {
+		return 0, err
+	}
+	return l.append(record)
+}
+
+// compactRecord is a record of a segment being compacted.
+type compactRecord struct {
+	offset int64 // In the segment
+	size   int64 // Bytes taken in the file
+	data   int64 // Bytes of data
+	flags  byte
+	keep   bool
+}
+
+// compactRun is one of the segments written in place of a compacted one.
+type compactRun struct {
+	base     int64
+	features uint16 // Flags of the records written
+	tmp      string // While it is written
+	done     string // Once complete
+	out      *os.File
+	w        *bufio.Writer
+}
+
+// Compact drops the records of the log that are followed by another record
+// with the same key, and the tombstones older than DB.TombstoneGrace. The
+// segment being written is left as it is.
+//
+// It does not stop writers nor readers: segments are compacted into new
+// files that replace them when done. Readers walking a replaced segment keep
+// reading it until they are done, as with the retention policy.
+//
+// Compacting reads the whole log, so it does nothing if no segment was
+// sealed since the last time, unless a tombstone it kept is due.
+func (l *Log) Compact() error {
+	l.cm.Lock()
+	defer l.cm.Unlock()
+	segs := l.acquire()
+	defer l.release(segs)
+	if len(segs) < 2 {
+		return nil
+	}
+	active := segs[len(segs)-1].base
+	if active == l.compacted && (l.tombstoneDue.IsZero() || time.Now().Before(l.tombstoneDue)) {
+		return nil
+	}
+	l.tombstoneDue = time.Time{}
+
+	latest, err := l.latest(segs)
+	if err != nil {
+		return err
+	}
+	// Oldest first, so a tombstone is never dropped while an older record
+	// of its key is kept
+	for _, s := range segs[:len(segs)-1] {
+		if err := l.compact(s, latest); err != nil {
+			return err
+		}
+	}
+	l.compacted = active
+	return nil
+}
+
+// latest returns the offset of the last record of every key.
+func (l *Log) latest(segs []*segment) (map[string]int64, error) {
+	latest := map[string]int64{}
+	for _, s := range segs {
+		base := s.base
+		_, err := s.Snapshot().Scan(0, func(info RecordInfo) error {
+			if len(info.Meta.Key) > 0 {
+				latest[string(info.Meta.Key)] = base + info.Offset
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return latest, nil
+}
+
+// compact writes the records of s that are kept to new segments and puts
+// them in its place. It does nothing if all of them are kept.
+func (l *Log) compact(s *segment, latest map[string]int64) error {
+	records, dropped, err := l.plan(s, latest)
+	if err != nil || !dropped {
+		return err
+	}
+
+	var runs []*compactRun
+	defer func() {
+		for _, run := range runs {
+			run.out.Close()
+			os.Remove(run.tmp)
+		}
+	}()
+	var run *compactRun
+	var pending []compactRecord // Dropped since the last record kept
+	end := int64(0)             // Of the last record kept
+	for _, rec := range records {
+		if !rec.keep {
+			pending = append(pending, rec)
+			continue
+		}
+		if run == nil || rec.offset-end >= s.Size()/compactGap {
+			if run != nil {
+				if err := run.finish(); err != nil {
+					return err
+				}
+			}
+			if run, err = l.newRun(s.base + rec.offset); run != nil {
+				runs = append(runs, run)
+			}
+			if err != nil {
+				return err
+			}
+		} else {
+			for _, p := range pending {
+				padding := encode(flagPadding|p.flags&flagTrailer, make([]byte, p.data))
+				if _, err := run.w.Write(padding); err != nil {
+					return err
+				}
+				run.features |= flagPadding | uint16(p.flags&flagTrailer)
+			}
+		}
+		pending = pending[:0]
+		if _, err := io.Copy(run.w, s.section(rec.offset, rec.size)); err != nil {
+			return err
+		}
+		run.features |= uint16(rec.flags)
+		end = rec.offset + rec.size
+	}
+	if run != nil {
+		if err := run.finish(); err != nil {
+			return err
+		}
+	}
+	for _, run := range runs {
+		if err := s.keepAge(run.tmp); err != nil {
+			return err
+		}
+	}
+	return l.replace(s, runs)
+}
+
+// plan reads the records of s telling which ones are kept.
+func (l *Log) plan(s *segment, latest map[string]int64) (records []compactRecord, dropped bool, err error) {
+	size := s.Size()
+	buf := bufio.NewReader(s.section(0, size))
+	for offset := int64(0); offset < size; {
+		data, flags, err := readRecord(buf, offset, size-offset, l.db.maxRecordSize())
+		if err != nil {
+			return nil, false, err
+		}
+		rec := compactRecord{
+			offset: offset,
+			size:   recordSize(int64(len(data)), flags),
+			data:   int64(len(data)),
+			flags:  flags,
+		}
+		if rec.keep, err = l.keep(s.base+offset, flags, data, latest); err != nil {
+			return nil, false, err
+		}
+		// Padding left by an earlier compaction is merged with the
+		// records dropped next to it, but is no reason to compact again
+		dropped = dropped || !rec.keep && flags&flagPadding == 0
+		records = append(records, rec)
+		offset += rec.size
+	}
+	return records, dropped, nil
+}
+
+// keep tells if the record at offset in the log survives compaction.
+func (l *Log) keep(offset int64, flags byte, data []byte, latest map[string]int64) (bool, error) {
+	if flags&flagPadding != 0 {
+		return false, nil
+	}
+	meta, _, err := splitMeta(offset, flags, data)
+	if err != nil {
+		return false, err
+	}
+	if len(meta.Key) == 0 {
+		return true, nil
+	}
+	if latest[string(meta.Key)] != offset {
+		return false, nil
+	}
+	if flags&flagTombstone == 0 {
+		return true, nil
+	}
+	if due := meta.Time.Add(l.db.TombstoneGrace); time.Now().Before(due) {
+		if l.tombstoneDue.IsZero() || due.Before(l.tombstoneDue) {
+			l.tombstoneDue = due
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// newRun starts a new segment at base. It is written next to the log and
+// renamed into place by replace.
+func (l *Log) newRun(base int64) (*compactRun, error) {
+	done := filepath.Join(l.dir, segmentName(base)) + compactExt
+	out, err := os.OpenFile(done+rewriteExt, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	run := &compactRun{base: base, tmp: done + rewriteExt, done: done, out: out, w: bufio.NewWriter(out)}
+	// The features are written once the records are known, see finish
+	_, err = run.w.Write(encodeFileHeader(0))
+	return run, err
+}
+
+func (run *compactRun) finish() error {
+	err := run.w.Flush()
+	if err == nil {
+		_, err = run.out.WriteAt(encodeFileHeader(run.features), 0)
+	}
+	if err == nil {
+		err = run.out.Sync()
+	}
+	if cerr := run.out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// replace puts the segments written by compact in the place of s.
+//
+// The runs are renamed with compactExt first. Replacing the file of s with
+// the run starting where s does, or removing it if there is none, commits
+// the compaction: if the process stops before, OpenLog finds s whole and
+// removes the runs, and if it stops after, it renames them into place.
+func (l *Log) replace(s *segment, runs []*compactRun) error {
+	l.db.cm.Lock()
+	defer l.db.cm.Unlock()
+	l.m.Lock()
+	defer l.m.Unlock()
+	i := slices.Index(l.segs, s)
+	if i < 0 {
+		// Dropped by the retention policy or TruncateBefore meanwhile
+		return nil
+	}
+
+	abort := func(err error) error {
+		for _, run := range runs {
+			os.Remove(run.done)
+		}
+		return err
+	}
+	for _, run := range runs {
+		if err := os.Rename(run.tmp, run.done); err != nil {
+			return abort(err)
+		}
+	}
+	if err := syncDir(l.dir); err != nil {
+		return abort(err)
+	}
+	rest := runs
+	if len(runs) > 0 && runs[0].base == s.base {
+		if err := os.Rename(runs[0].done, s.name); err != nil {
+			return abort(err)
+		}
+		s.replaced = true
+		rest = runs[1:]
+	} else if err := os.Remove(s.name); err != nil {
+		return abort(err)
+	}
+	for _, run := range rest {
+		if err := os.Rename(run.done, filepath.Join(l.dir, segmentName(run.base))); err != nil {
+			return err
+		}
+	}
+	if err := syncDir(l.dir); err != nil {
+		return err
+	}
+
+	fresh := make([]*segment, 0, len(runs))
+	for _, run := range runs {
+		f, err := l.db.openFile(filepath.Join(l.dir, segmentName(run.base)))
+		if err != nil {
+			for _, c := range fresh {
+				c.close()
+			}
+			return err
+		}
+		fresh = append(fresh, &segment{File: f, base: run.base, created: s.created})
+	}
+	l.segs = slices.Replace(l.segs, i, i+1, fresh...)
+	s.doom()
+	return nil
+}
+
+// recoverCompact finishes or undoes the compactions that were being
+// committed when the process stopped, given the bases of the segments left
+// with compactExt. Those found inside a segment were copied from it, which
+// is still whole: they are removed. The others are renamed into place.
+func (l *Log) recoverCompact(compacted []int64) error {
+	if len(compacted) == 0 {
+		return nil
+	}
+	for _, base := range compacted {
+		path := filepath.Join(l.dir, segmentName(base))
+		whole := slices.ContainsFunc(l.segs, func(s *segment) bool {
+			return s.base <= base && base < s.base+s.Size()
+		})
+		if whole {
+			os.Remove(path + compactExt)
+			continue
+		}
+		if err := os.Rename(path+compactExt, path); err != nil {
+			return err
+		}
+		f, err := l.db.openFile(path)
+		if err != nil {
+			return err
+		}
+		info, err := f.f.Stat()
+		if err != nil {
+			f.close()
+			return err
+		}
+		l.segs = append(l.segs, &segment{File: f, base: base, created: info.ModTime()})
+	}
+	sort.Slice(l.segs, func(i, j int) bool { return l.segs[i].base < l.segs[j].base })
+	return syncDir(l.dir)
+}
@@ -0,0 +1,370 @@
+package appender
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// appendKeyed writes every pair of key and value, returning their offsets.
+func appendKeyed(t *testing.T, l *Log, pairs ...string) []int64 {
+	var offsets []int64
+	for i := 0; i+1 < len(pairs); i += 2 {
+		offset, err := l.AppendMeta(Meta{Key: []byte(pairs[i])}, []byte(pairs[i+1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, offset)
+	}
+	return offsets
+}
+
+func TestCompact(t *testing.T) {
+	db := &DB{Root: t.TempDir(), SegmentSize: 1} // A record per segment
+	l, err := db.OpenLog("log_compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	offsets := appendKeyed(t, l, "a", "1", "b", "1", "a", "2")
+	plain, _ := l.Append([]byte("no key"))
+	tombstone, _ := l.AppendTombstone([]byte("b"))
+	offsets = append(offsets, appendKeyed(t, l, "c", "1", "a", "3")...)
+
+	if err = l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadAllLog(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last record is in the segment being written, so it is kept
+	if err = Compare(data, []string{"no key", "1", "3"}); err != nil {
+		t.Error(err)
+	}
+	if entry, err := l.ReadEntry(plain); err != nil || string(entry) != "no key" {
+		t.Error("Expected offsets to be kept. Got", string(entry), err)
+	}
+	if entry, err := l.ReadEntry(offsets[3]); err != nil || string(entry) != "1" {
+		t.Error("Expected offsets to be kept. Got", string(entry), err)
+	}
+	if l.First() != plain {
+		t.Error("Expected the log to start at", plain, "Got", l.First())
+	}
+	if _, err = l.ReadEntry(tombstone); err != Compacted {
+		t.Error("Expected Compacted. Got", err)
+	}
+	next, err := l.WalkFrom(tombstone, func(e Entry) error { return nil })
+	if err != nil || next != l.Size() {
+		t.Error("Expected to walk from a dropped record. Got", next, err)
+	}
+
+	// Nothing else to drop
+	if err = l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if l, err = db.OpenLog("log_compact"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = ReadAllLog(l); err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"no key", "1", "3"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompactPadding(t *testing.T) {
+	db := &DB{Root: t.TempDir(), Trailer: true}
+	l, err := db.OpenLog("log_compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// A short gap is padded, a long one is cut out
+	offsets := appendKeyed(t, l, "a", "1", "b", "1", "c", "1", "b", "2")
+	for i := 0; i < 20; i++ {
+		appendKeyed(t, l, "d", "0123456789")
+	}
+	offsets = append(offsets, appendKeyed(t, l, "e", "1")...)
+	s := l.segs[0]
+	l.roll(s.base + s.Size())
+	appendKeyed(t, l, "c", "2", "d", "last")
+
+	if err = l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.segs) != 3 {
+		t.Error("Expected the segment to be split in two. Got", len(l.segs)-1)
+	}
+	data, err := ReadAllLog(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"1", "2", "1", "2", "last"}); err != nil {
+		t.Error(err)
+	}
+	if _, err = l.ReadEntry(offsets[1]); err != Compacted {
+		t.Error("Expected Compacted for a padded record. Got", err)
+	}
+	if entry, err := l.ReadEntry(offsets[4]); err != nil || string(entry) != "1" {
+		t.Error("Expected offsets to be kept. Got", string(entry), err)
+	}
+	last, err := l.ReadLast(5)
+	if err != nil || len(last) != 5 || last[0].Offset != offsets[0] || last[1].Offset != offsets[3] {
+		t.Error("Expected to read the compacted log backwards. Got", last, err)
+	}
+}
+
+func TestCompactTombstoneGrace(t *testing.T) {
+	db := &DB{Root: t.TempDir(), SegmentSize: 1, TombstoneGrace: time.Hour}
+	l, err := db.OpenLog("log_compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	appendKeyed(t, l, "a", "1")
+	tombstone, _ := l.AppendTombstone([]byte("a"))
+	appendKeyed(t, l, "b", "1")
+	if err = l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	err = l.Walk(func(e Entry) error {
+		keys = append(keys, string(e.Meta.Key)+"="+string(e.Data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(keys, []string{"a=", "b=1"}); err != nil {
+		t.Error(err)
+	}
+	if l.First() != tombstone {
+		t.Error("Expected the log to start at the tombstone. Got", l.First())
+	}
+}
+
+func TestCompactNothingSealed(t *testing.T) {
+	db := &DB{Root: t.TempDir(), SegmentSize: 1, TombstoneGrace: 50 * time.Millisecond}
+	l, err := db.OpenLog("log_compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	appendKeyed(t, l, "a", "1")
+	l.AppendTombstone([]byte("a"))
+	appendKeyed(t, l, "b", "1")
+	if err = l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sealed segments are not read again
+	raw, _ := os.ReadFile(l.segs[0].name)
+	damaged := append([]byte(nil), raw...)
+	damaged[len(damaged)-1] ^= 1
+	os.WriteFile(l.segs[0].name, damaged, 0600)
+	if err = l.Compact(); err != nil {
+		t.Fatal("Expected nothing to compact. Got", err)
+	}
+	os.WriteFile(l.segs[0].name, raw, 0600)
+
+	// Until the tombstone kept is due
+	time.Sleep(60 * time.Millisecond)
+	if err = l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadAllLog(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"1"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompactEmptyValue(t *testing.T) {
+	db := &DB{Root: t.TempDir(), SegmentSize: 1}
+	l, err := db.OpenLog("log_compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Only AppendTombstone writes tombstones
+	offsets := appendKeyed(t, l, "a", "", "b", "1")
+	if err = l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := l.ReadEntry(offsets[0]); err != nil || len(entry) != 0 {
+		t.Error("Expected an empty value to be kept. Got", entry, err)
+	}
+}
+
+func TestCompactAge(t *testing.T) {
+	db := &DB{Root: t.TempDir(), RetentionAge: time.Hour}
+	l, err := db.OpenLog("log_compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	offsets := appendKeyed(t, l, "a", "1", "a", "2", "c", "1")
+	l.roll(l.Size())
+	appendKeyed(t, l, "b", "1")
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(l.segs[0].name, old, old)
+
+	// The copies of a segment are as old as the segment
+	if err = l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if l.First() != offsets[1] || l.segs[0].age() < time.Hour {
+		t.Error("Expected the compacted segment to keep its age. Got", l.First(), l.segs[0].age())
+	}
+	if err = l.TruncateBefore(offsets[2]); err != nil {
+		t.Fatal(err)
+	}
+	if l.First() != offsets[2] || l.segs[0].age() < time.Hour {
+		t.Error("Expected the truncated segment to keep its age. Got", l.First(), l.segs[0].age())
+	}
+	if events := l.EnforceRetention(); len(events) != 1 || events[0].Reason != "age" {
+		t.Error("Expected the segment to be removed for its age. Got", events)
+	}
+}
+
+func TestCompactCrash(t *testing.T) {
+	root := t.TempDir()
+	db := &DB{Root: root}
+	l, err := db.OpenLog("log_compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendKeyed(t, l, "a", "1")
+	for i := 0; i < 10; i++ {
+		appendKeyed(t, l, "b", "0123456789")
+	}
+	appendKeyed(t, l, "c", "1")
+	l.roll(l.Size())
+	appendKeyed(t, l, "b", "2")
+
+	first := filepath.Join(root, "log_compact", segmentName(0))
+	old, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	second := l.segs[1].name
+	l.Close()
+
+	// Stopped before replacing the first segment
+	os.Rename(first, first+compactExt)
+	os.WriteFile(first, old, 0600)
+	os.Rename(second, second+compactExt)
+	if l, err = db.OpenLog("log_compact"); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.segs) != 2 {
+		t.Error("Expected the compacted segments to be dropped. Got", len(l.segs), "segments")
+	}
+	data, err := ReadAllLog(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 13 {
+		t.Error("Expected every record back. Got", len(data))
+	}
+	if _, err = os.Stat(second + compactExt); !os.IsNotExist(err) {
+		t.Error("Expected the compacted segments to be removed")
+	}
+
+	// Stopped right after replacing it
+	if err = l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	os.Rename(second, second+compactExt)
+	if l, err = db.OpenLog("log_compact"); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if data, err = ReadAllLog(l); err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"1", "1", "2"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompactConcurrent(t *testing.T) {
+	db := &DB{Root: t.TempDir(), SegmentSize: 256}
+	l, err := db.OpenLog("log_compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 500; i++ {
+			key := string(rune('a' + i%5))
+			if _, err := l.AppendMeta(Meta{Key: []byte(key)}, []byte("value")); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := l.Compact(); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := l.Walk(func(e Entry) error { return nil }); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	// Seal the last records too
+	l.roll(l.Size())
+	if err = l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]int{}
+	err = l.Walk(func(e Entry) error {
+		keys[string(e.Meta.Key)]++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, n := range keys {
+		if n != 1 {
+			t.Error("Expected the records of", key, "to be compacted. Got", n)
+		}
+	}
+	if len(keys) != 5 {
+		t.Error("Expected 5 keys. Got", keys)
+	}
+}
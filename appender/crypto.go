@@ -0,0 +1,106 @@
+package appender
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// KeyProvider gives the AES keys (16, 24 or 32 bytes long) used to encrypt
+// records with AES-GCM. Every record is tagged with the id of its key: new
+// records use the current key and old ones keep being read with the key they
+// were written with, so keys can be rotated without rewriting files.
+type KeyProvider interface {
+	CurrentKey() (id uint32, key []byte, err error)
+	Key(id uint32) ([]byte, error)
+}
+
+// Keys is a KeyProvider backed by a map. The key with the highest id is the
+// current one.
+type Keys map[uint32][]byte
+
+// CurrentKey returns the key with the highest id.
+func (k Keys) CurrentKey() (id uint32, key []byte, err error) {
+	if len(k) == 0 {
+		return 0, nil, errors.New("appender: no keys")
+	}
+	for i, v := range k {
+		if key == nil || i > id {
+			id, key = i, v
+		}
+	}
+	return id, key, nil
+}
+
+// Key returns the key with the given id.
+func (k Keys) Key(id uint32) ([]byte, error) {
+	key, ok := k[id]
+	if !ok {
+		return nil, fmt.Errorf("appender: unknown key %d", id)
+	}
+	return key, nil
+}
+
+// An encrypted record holds the id of the key, a random nonce and then the
+// sealed data. The flags and the meta of the record are authenticated with
+// it.
+const keyIDSize = 4
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encrypt(keys KeyProvider, aad, data []byte) ([]byte, error) {
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, keyIDSize+gcm.NonceSize(), keyIDSize+gcm.NonceSize()+len(data)+gcm.Overhead())
+	binary.LittleEndian.PutUint32(out, id)
+	nonce := out[keyIDSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(out, nonce, data, aad), nil
+}
+
+func decrypt(keys KeyProvider, offset int64, aad, data []byte) ([]byte, error) {
+	if keys == nil {
+		return nil, fmt.Errorf("appender: record at offset %d is encrypted and there are no Keys", offset)
+	}
+	if len(data) < keyIDSize {
+		return nil, &CorruptionError{Offset: offset, Reason: "missing key id"}
+	}
+	id := binary.LittleEndian.Uint32(data)
+	key, err := keys.Key(id)
+	if err != nil {
+		return nil, fmt.Errorf("appender: record at offset %d: %v", offset, err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data = data[keyIDSize:]
+	if len(data) < gcm.NonceSize() {
+		return nil, &CorruptionError{Offset: offset, Reason: "missing nonce"}
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		return nil, &CorruptionError{Offset: offset, Reason: "authentication failed, the record was tampered with"}
+	}
+	return plain, nil
+}
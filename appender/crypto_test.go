@@ -0,0 +1,83 @@
+package appender
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	keys := Keys{1: bytes.Repeat([]byte{1}, 32)}
+	db := &DB{Keys: keys, Codec: Flate}
+	db.Remove("user_crypto")
+	defer db.Remove("user_crypto")
+
+	f, err := db.Open("user_crypto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	secret := "my credit card number is 1234 1234 1234 1234"
+	f.Write([]byte(secret))
+	f.Write([]byte(secret))
+
+	// Rotate the key
+	keys[2] = bytes.Repeat([]byte{2}, 16)
+	f.WriteBatch([][]byte{[]byte("hello"), []byte("world")})
+
+	raw, err := os.ReadFile("user_crypto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("credit card")) || bytes.Contains(raw, []byte("hello")) {
+		t.Fatal("Data stored in clear")
+	}
+
+	data, err := ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{secret, secret, "hello", "world"}); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Keys = Keys{2: keys[2]}
+	if _, err = ReadAll(f); err == nil {
+		t.Fatal("Expected an error without the old key")
+	}
+	db.Keys = nil
+	if _, err = ReadAll(f); err == nil {
+		t.Fatal("Expected an error without keys")
+	}
+}
+
+func TestTampering(t *testing.T) {
+	db := &DB{Keys: Keys{1: bytes.Repeat([]byte{1}, 32)}}
+	db.Remove("user_tamper")
+	defer db.Remove("user_tamper")
+
+	f, err := db.Open("user_tamper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("hello"))
+
+	// Change the data and fix the checksum, as an attacker would do
+	raw, _ := os.ReadFile("user_tamper")
+	record := raw[fileHeaderSize:]
+	data := record[headerSize:]
+	data[len(data)-1] ^= 1
+	size, flags, _ := decodeHeader(record)
+	copy(record, encode(flags, data)[:headerSize])
+	if size != int64(len(data)) {
+		t.Fatal("Unexpected size", size)
+	}
+	os.WriteFile("user_tamper", raw, 0600)
+
+	_, err = ReadAll(f)
+	if cerr, ok := err.(*CorruptionError); !ok || cerr.Offset != 0 {
+		t.Fatal("Expected a corruption error. Got", err)
+	}
+}
@@ -0,0 +1,73 @@
+package appender
+
+import (
+	"context"
+	"time"
+)
+
+// Follow reads the records from offset on, like IterateFrom, and then keeps
+// waiting for new ones until ctx is done. It returns the offset following
+// the last record read together with the error of the context.
+//
+// Records written through f wake up Follow right away. Records written by
+// other processes are found checking the file every DB.PollInterval.
+func (f *File) Follow(ctx context.Context, offset int64, iterator Iterator) (next int64, err error) {
+	var poll <-chan time.Time
+	if f.db.PollInterval > 0 {
+		ticker := time.NewTicker(f.db.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		// Take the channel before reading so no write is missed
+		wake := f.changed()
+
+		size, err := f.visibleSize()
+		if err != nil {
+			return offset, err
+		}
+		r := &Reader{f: f, size: size}
+		offset, err = r.IterateFrom(offset, iterator)
+		if err != nil {
+			// Another process is still writing the record
+			if cerr, ok := err.(*CorruptionError); !ok || !cerr.short || size == f.Size() {
+				return offset, err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return offset, ctx.Err()
+		case <-wake:
+		case <-poll:
+		}
+	}
+}
+
+// changed returns a channel that is closed on the next write.
+func (f *File) changed() <-chan struct{} {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.wake == nil {
+		f.wake = make(chan struct{})
+	}
+	return f.wake
+}
+
+// visibleSize returns how much of the file can be read. When polling it
+// includes what other processes appended.
+func (f *File) visibleSize() (int64, error) {
+	size := f.Size()
+	if f.db.PollInterval == 0 {
+		return size, nil
+	}
+	info, err := f.f.Stat()
+	if err != nil {
+		return size, err
+	}
+	if info.Size()-f.start > size {
+		size = info.Size() - f.start
+	}
+	return size, nil
+}
@@ -0,0 +1,98 @@
+package appender
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func follow(f *File, ctx context.Context) <-chan string {
+	c := make(chan string, 10)
+	go func() {
+		defer close(c)
+		f.Follow(ctx, 0, func(entry io.Reader) {
+			readed, _ := ioutil.ReadAll(entry)
+			c <- string(readed)
+		})
+	}()
+	return c
+}
+
+func expect(t *testing.T, c <-chan string, expected ...string) {
+	for _, e := range expected {
+		select {
+		case s := <-c:
+			if s != e {
+				t.Fatal("Expected", e, "Got", s)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for", e)
+		}
+	}
+}
+
+func TestFollow(t *testing.T) {
+	db := &DB{}
+	db.Remove("user_follow")
+	defer db.Remove("user_follow")
+
+	f, err := db.Open("user_follow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("hello"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := follow(f, ctx)
+	expect(t, c, "hello")
+
+	f.Write([]byte("world"))
+	f.Write([]byte("!"))
+	expect(t, c, "world", "!")
+
+	cancel()
+	if _, open := <-c; open {
+		t.Fatal("Follow should stop when the context is cancelled")
+	}
+}
+
+func TestFollowOtherProcess(t *testing.T) {
+	db := &DB{}
+	db.Remove("user_follow_other")
+	defer db.Remove("user_follow_other")
+
+	writer, err := db.Open("user_follow_other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	reader, err := (&DB{PollInterval: 5 * time.Millisecond}).Open("user_follow_other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := follow(reader, ctx)
+
+	writer.Write([]byte("hello"))
+	expect(t, c, "hello")
+
+	// Half written record
+	raw, err := os.OpenFile("user_follow_other", os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	record := encode(0, []byte("world"))
+	raw.Write(record[:headerSize+2])
+	time.Sleep(20 * time.Millisecond)
+	raw.Write(record[headerSize+2:])
+	expect(t, c, "world")
+}
@@ -0,0 +1,267 @@
+package appender
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Files start with a header made of fileMagic, the version of the format and
+// the features used by the file as uint16 little endian, and a CRC32C of
+// all of them. The last byte of the magic sets every flag when read as the
+// flags of a record, which is never written, and most of them were unknown
+// before headers existed, so a header is never taken for the first record
+// of a file written before.
+//
+// Offsets count from the end of the header, so the records of a file keep
+// their offsets when it is migrated.
+const (
+	fileMagic      = "\x89APND\r\n\xff"
+	fileHeaderSize = 8 + 2 + 2 + 4 // Magic, version, features and checksum
+)
+
+// FormatVersion is the version of the format of the files created by this
+// package. Files written before versions existed are version 0: they have
+// no header but are otherwise read the same way. The ones written before
+// records had checksums are migrated when opened, see legacyHeaderSize.
+const FormatVersion = 1
+
+// The features of a file are the record flags used by its records. They are
+// added to the header when a record uses one for the first time, so a reader
+// refuses the files holding records it does not know how to read, instead of
+// failing at the first of them, but reads the ones that do not use them.
+
+// encodeFileHeader returns the header of a file using features.
+func encodeFileHeader(features uint16) []byte {
+	buf := make([]byte, fileHeaderSize)
+	copy(buf, fileMagic)
+	binary.LittleEndian.PutUint16(buf[len(fileMagic):], FormatVersion)
+	binary.LittleEndian.PutUint16(buf[len(fileMagic)+2:], features)
+	binary.LittleEndian.PutUint32(buf[fileHeaderSize-4:], crc32.Checksum(buf[:fileHeaderSize-4], castagnoli))
+	return buf
+}
+
+// readFileHeader finds out the version of the file, writing the header of
+// new files if it is writable. It sets f.start and f.version.
+func (f *File) readFileHeader(writable bool) error {
+	info, err := f.f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	buf := make([]byte, min(size, fileHeaderSize))
+	if _, err := f.f.ReadAt(buf, 0); err != nil {
+		return err
+	}
+	header := encodeFileHeader(0)
+	if size < fileHeaderSize && bytes.HasPrefix(header, buf) {
+		// A new file, or one whose header was not completely written.
+		// Either way it holds no records.
+		if !writable {
+			f.start, f.version = size, FormatVersion
+			return nil
+		}
+		if err := f.f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.f.Write(header); err != nil {
+			return err
+		}
+		f.start, f.version = fileHeaderSize, FormatVersion
+		return nil
+	}
+
+	if !bytes.HasPrefix(buf, []byte(fileMagic)) {
+		// Written before headers existed. Make sure it starts with a record
+		// so a random file is never taken for a damaged one and repaired.
+		_, _, err := readRecord(io.NewSectionReader(f.f, 0, size), 0, size, lengthMask)
+		if _, ok := err.(*CorruptionError); ok {
+			// Or it was written before records had checksums, and
+			// taking it for a damaged file would truncate its records
+			legacy, err := isLegacy(f.f, size, f.db.maxRecordSize())
+			if err != nil {
+				return err
+			}
+			if legacy {
+				return LegacyFormat
+			}
+			return NotAppenderFile
+		}
+		if err != nil {
+			return err
+		}
+		f.start, f.version = 0, 0
+		return nil
+	}
+
+	if len(buf) < fileHeaderSize || crc32.Checksum(buf[:fileHeaderSize-4], castagnoli) != binary.LittleEndian.Uint32(buf[fileHeaderSize-4:]) {
+		return NotAppenderFile
+	}
+	version := binary.LittleEndian.Uint16(buf[len(fileMagic):])
+	features := binary.LittleEndian.Uint16(buf[len(fileMagic)+2:])
+	if version > FormatVersion || features&^knownFlags != 0 {
+		return UnsupportedFormat
+	}
+	f.start, f.version, f.features = fileHeaderSize, int(version), features
+	return nil
+}
+
+// addFeatures adds to the header the flags of the records in raw that no
+// record of the file used before. f.m must be held.
+func (f *File) addFeatures(raw []byte) error {
+	if f.version == 0 {
+		return nil
+	}
+	used := recordFeatures(raw)
+	if used&^f.features == 0 {
+		return nil
+	}
+	// f.f appends every write
+	w, err := os.OpenFile(f.name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = w.WriteAt(encodeFileHeader(f.features|used), 0)
+	if err == nil {
+		err = w.Sync()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	f.features |= used
+	return nil
+}
+
+// recordFeatures returns the flags used by the records in raw.
+func recordFeatures(raw []byte) uint16 {
+	var used uint16
+	for p := int64(0); p < int64(len(raw)); {
+		n, flags, _ := decodeHeader(raw[p:])
+		used |= uint16(flags)
+		p += recordSize(n, flags)
+	}
+	return used
+}
+
+// Version returns the version of the format of the file.
+func (f *File) Version() int {
+	return f.version
+}
+
+// Migrate rewrites the file called name in the current format. Its records
+// are copied as they are and keep their offsets, unless they were written
+// before records had checksums: those are framed again. A file in use can
+// not be migrated.
+func (db *DB) Migrate(name string) error {
+	path, err := db.path(name)
+	if err != nil {
+		return err
+	}
+	db.m.Lock()
+	defer db.m.Unlock()
+	if f, ok := db.files[path]; ok {
+		if f.refs > 0 {
+			return FileInUse
+		}
+		db.forget(f)
+		f.close()
+	}
+	return db.MigratePath(path)
+}
+
+// MigrateLog migrates every segment of the log called name. The log must not
+// be open.
+func (db *DB) MigrateLog(name string) error {
+	dir, err := db.path(name)
+	if err != nil {
+		return err
+	}
+	segs, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		return err
+	}
+	for _, s := range segs {
+		if err := db.MigratePath(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigratePath is like Migrate for the file at path, see OpenPath. The file
+// must not be open.
+func (db *DB) MigratePath(path string) error {
+	old, err := db.openFile(path)
+	if err != nil {
+		return err
+	}
+	defer old.close()
+	if old.version == FormatVersion {
+		return nil
+	}
+
+	var features uint16
+	err = old.scan(0, 0, func(record, offset int64, hdr []byte) error {
+		_, flags, _ := decodeHeader(hdr)
+		features |= uint16(flags)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, features, func(w io.Writer) error {
+		_, err := io.Copy(w, old.section(0, old.Size()))
+		return err
+	})
+}
+
+// replaceFile replaces the file at path with a new one holding a file header
+// with features and the records given to w by write. The new file is
+// written next to the old one and renamed over it once complete.
+func replaceFile(path string, features uint16, write func(w io.Writer) error) error {
+	tmp := path + ".migrate"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	w := bufio.NewWriter(f)
+	_, err = w.Write(encodeFileHeader(features))
+	if err == nil {
+		err = write(w)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes the changes to the entries of dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
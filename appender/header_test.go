@@ -0,0 +1,208 @@
+package appender
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileHeader(t *testing.T) {
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := db.Open("user_header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset, _ := f.Append([]byte("hello"))
+	f.Close()
+	if f.Version() != FormatVersion || offset != 0 {
+		t.Error("Expected the first record at 0 in a new file. Got", f.Version(), offset)
+	}
+
+	path, _ := db.path("user_header")
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(raw, []byte(fileMagic)) || len(raw) != fileHeaderSize+headerSize+5 {
+		t.Fatal("Expected a file header", raw)
+	}
+
+	// A file from a newer version is refused
+	newer := append([]byte(nil), raw...)
+	binary.LittleEndian.PutUint16(newer[len(fileMagic):], FormatVersion+1)
+	os.WriteFile(path, newer, 0600)
+	if _, err = db.Open("user_header"); err != NotAppenderFile {
+		t.Error("Expected a damaged header to be refused. Got", err)
+	}
+	binary.LittleEndian.PutUint32(newer[fileHeaderSize-4:], crc32.Checksum(newer[:fileHeaderSize-4], castagnoli))
+	os.WriteFile(path, newer, 0600)
+	if _, err = db.Open("user_header"); err != UnsupportedFormat {
+		t.Error("Expected UnsupportedFormat. Got", err)
+	}
+
+	// A header cut short holds no records
+	os.WriteFile(path, raw[:5], 0600)
+	if f, err = db.Open("user_header"); err != nil {
+		t.Fatal(err)
+	}
+	if f.Size() != 0 || f.Version() != FormatVersion {
+		t.Error("Expected an empty file. Got", f.Size())
+	}
+	f.Close()
+}
+
+func TestNotAppenderFile(t *testing.T) {
+	dir := t.TempDir()
+	db := &DB{Repair: true}
+	for _, content := range []string{"hello world\n", "\x05\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00hello"} {
+		path := filepath.Join(dir, "random")
+		os.WriteFile(path, []byte(content), 0600)
+		if _, err := db.OpenPath(path); err != NotAppenderFile {
+			t.Errorf("Expected NotAppenderFile for %q. Got %v", content, err)
+		}
+		if raw, _ := os.ReadFile(path); string(raw) != content {
+			t.Error("The file should be left untouched. Got", raw)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.IndexInterval = 1
+	path, _ := db.path("user_migrate")
+
+	// Write a file without header, as old versions did
+	old := append(encode(0, []byte("hello")), encode(flagTrailer, []byte("world"))...)
+	os.WriteFile(path, old, 0600)
+	f, err := db.Open("user_migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Version() != 0 {
+		t.Error("Expected version 0. Got", f.Version())
+	}
+	offset, _ := f.Append([]byte("!"))
+	if offset != int64(len(old)) {
+		t.Error("Expected offsets to start at the beginning of the file. Got", offset)
+	}
+
+	if err = db.Migrate("user_migrate"); err != FileInUse {
+		t.Error("Expected FileInUse. Got", err)
+	}
+	f.Close()
+	if err = db.Migrate("user_migrate"); err != nil {
+		t.Fatal(err)
+	}
+
+	if f, err = db.Open("user_migrate"); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Version() != FormatVersion {
+		t.Error("Expected the current version. Got", f.Version())
+	}
+	if data, err := f.ReadEntry(offset); err != nil || string(data) != "!" {
+		t.Error("Expected offsets to be kept. Got", string(data), err)
+	}
+	if got, err := f.SeekRecord(2); err != nil || got != offset {
+		t.Error("Expected the index to be kept. Got", got, err)
+	}
+	data, err := ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"hello", "world", "!"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path + ".migrate"); !os.IsNotExist(err) {
+		t.Error("Expected the temporary file to be removed")
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, _ := db.path("user_migrate")
+	writeLegacy(t, path, "hello", "", "world")
+
+	if _, err = db.OpenPathReadOnly(path); err != LegacyFormat {
+		t.Fatal("Expected LegacyFormat. Got", err)
+	}
+	if err = db.Migrate("user_migrate"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := db.OpenPathReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Version() != FormatVersion {
+		t.Error("Expected the current version. Got", f.Version())
+	}
+	// Every record is checked against its checksum
+	if _, err = f.Snapshot().Scan(0, func(RecordInfo) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"hello", "", "world"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileFeatures(t *testing.T) {
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := db.Open("user_features")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Append([]byte("hello"))
+	path, _ := db.path("user_features")
+	features := func() uint16 {
+		raw, _ := os.ReadFile(path)
+		return binary.LittleEndian.Uint16(raw[len(fileMagic)+2:])
+	}
+	if features() != 0 {
+		t.Error("Expected no features. Got", features())
+	}
+
+	// Added by the first record using them
+	db.Trailer = true
+	f.Append([]byte("world"))
+	f.Close()
+	if features() != flagTrailer {
+		t.Error("Expected the trailer feature. Got", features())
+	}
+	if f, err = db.Open("user_features"); err != nil {
+		t.Fatal(err)
+	}
+	if f.features != flagTrailer {
+		t.Error("Expected the features to be read back. Got", f.features)
+	}
+	f.Close()
+
+	// A feature this version does not know
+	raw, _ := os.ReadFile(path)
+	binary.LittleEndian.PutUint16(raw[len(fileMagic)+2:], 1<<8)
+	binary.LittleEndian.PutUint32(raw[fileHeaderSize-4:], crc32.Checksum(raw[:fileHeaderSize-4], castagnoli))
+	os.WriteFile(path, raw, 0600)
+	if _, err = db.Open("user_features"); err != UnsupportedFormat {
+		t.Error("Expected UnsupportedFormat. Got", err)
+	}
+}
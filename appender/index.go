@@ -0,0 +1,267 @@
+package appender
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// The index of a file is kept next to it, in a file with the same name plus
+// indexExt. It has an entry every DB.IndexInterval records with the number of
+// the record, its time (zero if it has no Meta) and its offset, each as an
+// int64 little endian. It is only a cache: it is checked when the file is
+// opened and rebuilt if it is missing or does not match the file.
+const (
+	indexExt       = ".idx"
+	indexEntrySize = 3 * 8
+)
+
+type indexEntry struct {
+	record int64
+	time   int64
+	offset int64
+}
+
+type index struct {
+	f       *os.File
+	entries []indexEntry
+}
+
+// openIndex loads the index of the file, fixing it if needed. It is called
+// by Open once the file is recovered.
+func (f *File) openIndex() error {
+	idx, err := os.OpenFile(f.name+indexExt, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	f.index = &index{f: idx}
+
+	raw, err := io.ReadAll(idx)
+	if err != nil {
+		return err
+	}
+	entries := make([]indexEntry, 0, len(raw)/indexEntrySize)
+	for p := 0; p+indexEntrySize <= len(raw); p += indexEntrySize {
+		entries = append(entries, indexEntry{
+			record: int64(binary.LittleEndian.Uint64(raw[p:])),
+			time:   int64(binary.LittleEndian.Uint64(raw[p+8:])),
+			offset: int64(binary.LittleEndian.Uint64(raw[p+16:])),
+		})
+	}
+	if len(raw)%indexEntrySize != 0 || !f.validIndex(entries) {
+		// Rebuild it from scratch
+		if err := idx.Truncate(0); err != nil {
+			return err
+		}
+		entries = entries[:0]
+	}
+
+	// Add the records written after the last entry
+	record, offset := int64(0), int64(0)
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		record, offset = last.record, last.offset
+	}
+	f.index.entries = entries
+	return f.scan(record, offset, func(record, offset int64, hdr []byte) error {
+		if len(f.index.entries) == 0 || record > f.index.entries[len(f.index.entries)-1].record {
+			return f.indexRecord(record, offset, hdr)
+		}
+		return nil
+	})
+}
+
+// validIndex checks that the entries of the index belong to the file.
+func (f *File) validIndex(entries []indexEntry) bool {
+	interval := f.db.IndexInterval
+	size := f.Size()
+	for i, e := range entries {
+		if e.record != int64(i)*interval || e.offset >= size || i > 0 && e.offset <= entries[i-1].offset {
+			return false
+		}
+	}
+	if len(entries) == 0 {
+		return true
+	}
+	last := entries[len(entries)-1]
+	remaining := size - last.offset
+	data, flags, err := readRecord(f.section(last.offset, remaining), last.offset, remaining, f.db.maxRecordSize())
+	if err != nil {
+		return false
+	}
+	meta, _, err := splitMeta(last.offset, flags, data)
+	return err == nil && timeOf(meta) == last.time
+}
+
+func timeOf(m Meta) int64 {
+	if m.Time.IsZero() {
+		return 0
+	}
+	return m.Time.UnixNano()
+}
+
+// scan walks the headers of the records from the one numbered record at
+// offset up to the end of the file. hdr holds the header of the record
+// followed by the beginning of its data, enough to read the time of its
+// Meta.
+func (f *File) scan(record, offset int64, fn func(record, offset int64, hdr []byte) error) error {
+	size := f.Size()
+	buf := make([]byte, headerSize+8)
+	for offset < size {
+		hdr := buf[:min(int64(len(buf)), size-offset)]
+		if _, err := f.readAt(hdr, offset); err != nil {
+			return err
+		}
+		if len(hdr) < headerSize {
+			return &CorruptionError{Offset: offset, Reason: "truncated header"}
+		}
+		if err := fn(record, offset, hdr); err != nil {
+			return err
+		}
+		n, flags, _ := decodeHeader(hdr)
+		offset += recordSize(n, flags)
+		record++
+	}
+	return nil
+}
+
+// indexRecord adds the record to the index if it falls on the interval.
+// hdr is the beginning of the record, see scan.
+func (f *File) indexRecord(record, offset int64, hdr []byte) error {
+	if f.index == nil || record%f.db.IndexInterval != 0 {
+		return nil
+	}
+	e := indexEntry{record: record, offset: offset}
+	if _, flags, _ := decodeHeader(hdr); flags&flagMeta != 0 && len(hdr) >= headerSize+8 {
+		e.time = int64(binary.LittleEndian.Uint64(hdr[headerSize:]))
+	}
+	f.index.entries = append(f.index.entries, e)
+
+	var buf [indexEntrySize]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(e.record))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(e.time))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(e.offset))
+	_, err := f.index.f.Write(buf[:])
+	return err
+}
+
+// indexWritten indexes the records in raw, just written at offset. f.m must
+// be held. A failure to write the index is not reported: it is rebuilt the
+// next time the file is opened.
+func (f *File) indexWritten(offset int64, raw []byte, records int64) {
+	if f.index == nil {
+		return
+	}
+	record := f.records.Load() - records
+	for p := 0; p < len(raw); record++ {
+		f.indexRecord(record, offset+int64(p), raw[p:])
+		n, flags, _ := decodeHeader(raw[p:])
+		p += int(recordSize(n, flags))
+	}
+}
+
+// truncateIndex drops the entries of the records from offset onwards. f.m
+// must be held.
+func (f *File) truncateIndex(offset int64) {
+	if f.index == nil {
+		return
+	}
+	entries := f.index.entries
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].offset >= offset })
+	f.index.entries = entries[:i]
+	f.index.f.Truncate(int64(i) * indexEntrySize)
+}
+
+// closest returns the last index entry matching before, or the beginning of
+// the file.
+func (f *File) closest(before func(e indexEntry) bool) indexEntry {
+	f.m.Lock()
+	var entries []indexEntry
+	if f.index != nil {
+		entries = f.index.entries
+	}
+	f.m.Unlock()
+
+	i := sort.Search(len(entries), func(i int) bool { return !before(entries[i]) })
+	if i == 0 {
+		return indexEntry{}
+	}
+	return entries[i-1]
+}
+
+// SeekRecord returns the offset of the record number n, counting from zero.
+// A batch counts as one record.
+func (f *File) SeekRecord(n int64) (offset int64, err error) {
+	if n < 0 || n >= f.records.Load() {
+		return 0, OffsetOutOfRange
+	}
+	e := f.closest(func(e indexEntry) bool { return e.record <= n })
+	err = f.scan(e.record, e.offset, func(record, at int64, hdr []byte) error {
+		offset = at
+		if record == n {
+			return Stop
+		}
+		return nil
+	})
+	if err != Stop {
+		return 0, err
+	}
+	return offset, nil
+}
+
+// SeekTime returns the offset of the first record with a Meta time not
+// before t, or Size() if there is none. Records are expected to be written in
+// time order, see DB.Timestamps.
+func (f *File) SeekTime(t time.Time) (offset int64, err error) {
+	nano := t.UnixNano()
+	e := f.closest(func(e indexEntry) bool { return e.time < nano })
+	offset = f.Size()
+	err = f.scan(e.record, e.offset, func(record, at int64, hdr []byte) error {
+		_, flags, _ := decodeHeader(hdr)
+		if flags&flagMeta != 0 && len(hdr) >= headerSize+8 && int64(binary.LittleEndian.Uint64(hdr[headerSize:])) >= nano {
+			offset = at
+			return Stop
+		}
+		return nil
+	})
+	if err != nil && err != Stop {
+		return 0, err
+	}
+	return offset, nil
+}
+
+// SeekRecord returns the offset of the record number n of the log, counting
+// from the oldest record kept.
+func (l *Log) SeekRecord(n int64) (offset int64, err error) {
+	segs := l.acquire()
+	defer l.release(segs)
+	for _, s := range segs {
+		_, records := s.stats()
+		if n < records {
+			offset, err = s.SeekRecord(n)
+			return s.base + offset, err
+		}
+		n -= records
+	}
+	return 0, OffsetOutOfRange
+}
+
+// SeekTime returns the offset of the first record of the log with a Meta
+// time not before t, or Size() if there is none.
+func (l *Log) SeekTime(t time.Time) (offset int64, err error) {
+	segs := l.acquire()
+	defer l.release(segs)
+	for _, s := range segs {
+		offset, err = s.SeekTime(t)
+		if err != nil {
+			return 0, err
+		}
+		if offset < s.Size() {
+			return s.base + offset, nil
+		}
+	}
+	last := segs[len(segs)-1]
+	return last.base + last.Size(), nil
+}
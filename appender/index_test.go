@@ -0,0 +1,165 @@
+package appender
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSeek(t *testing.T) {
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.IndexInterval = 4
+
+	f, err := db.Open("user_seek")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	offsets := []int64{}
+	for i := 0; i < 50; i++ {
+		offset, err := f.AppendMeta(Meta{Time: start.Add(time.Duration(i) * time.Minute)}, []byte(fmt.Sprint(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, offset)
+	}
+	if len(f.index.entries) != 13 {
+		t.Fatal("Expected 13 index entries. Got", len(f.index.entries))
+	}
+
+	check := func(f *File) {
+		for _, n := range []int64{0, 3, 4, 17, 49} {
+			offset, err := f.SeekRecord(n)
+			if err != nil || offset != offsets[n] {
+				t.Error("Expected record", n, "at", offsets[n], "Got", offset, err)
+			}
+			offset, err = f.SeekTime(start.Add(time.Duration(n)*time.Minute - time.Second))
+			if err != nil || offset != offsets[n] {
+				t.Error("Expected time of record", n, "at", offsets[n], "Got", offset, err)
+			}
+		}
+		if _, err := f.SeekRecord(50); err != OffsetOutOfRange {
+			t.Error("Expected OffsetOutOfRange. Got", err)
+		}
+		if offset, _ := f.SeekTime(start.Add(time.Hour)); offset != f.Size() {
+			t.Error("Expected the end of the file. Got", offset)
+		}
+	}
+	check(f)
+	f.Close()
+
+	// A missing index is rebuilt
+	path, _ := db.path("user_seek")
+	if err = os.Remove(path + indexExt); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = db.Open("user_seek"); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.index.entries) != 13 {
+		t.Fatal("Expected the index to be rebuilt. Got", len(f.index.entries))
+	}
+	check(f)
+	f.Close()
+
+	// So is an index that does not match the file
+	if err = os.WriteFile(path+indexExt, make([]byte, 2*indexEntrySize), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = db.Open("user_seek"); err != nil {
+		t.Fatal(err)
+	}
+	check(f)
+	f.Close()
+
+	if err = db.Remove("user_seek"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path + indexExt); !os.IsNotExist(err) {
+		t.Error("Expected the index to be removed")
+	}
+}
+
+func TestStaleIndex(t *testing.T) {
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.IndexInterval = 2
+
+	f, err := db.Open("user_stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = WriteAll(f, []string{"0", "1", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// Records written without the index are added on the next Open
+	db.IndexInterval = 0
+	if f, err = db.Open("user_stale"); err != nil {
+		t.Fatal(err)
+	}
+	offset, _ := f.Append([]byte("3"))
+	last, _ := f.Append([]byte("4"))
+	f.Close()
+
+	db.IndexInterval = 2
+	if f, err = db.Open("user_stale"); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if n := len(f.index.entries); n != 3 || f.index.entries[2].offset != last {
+		t.Fatal("Expected the index to be extended. Got", f.index.entries)
+	}
+	if got, err := f.SeekRecord(3); err != nil || got != offset {
+		t.Error("Expected", offset, "Got", got, err)
+	}
+}
+
+func TestLogSeek(t *testing.T) {
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.IndexInterval = 2
+	db.SegmentSize = 3 * (headerSize + metaSize + 1)
+
+	l, err := db.OpenLog("log_seek")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	offsets := []int64{}
+	for i := 0; i < 10; i++ {
+		offset, err := l.AppendMeta(Meta{Time: start.Add(time.Duration(i) * time.Minute)}, []byte(fmt.Sprint(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, offset)
+	}
+	if segs, _ := filepath.Glob(filepath.Join(l.dir, "*"+indexExt)); len(segs) != 4 {
+		t.Error("Expected an index per segment. Got", segs)
+	}
+
+	for _, n := range []int64{0, 2, 3, 7, 9} {
+		offset, err := l.SeekRecord(n)
+		if err != nil || offset != offsets[n] {
+			t.Error("Expected record", n, "at", offsets[n], "Got", offset, err)
+		}
+		offset, err = l.SeekTime(start.Add(time.Duration(n) * time.Minute))
+		if err != nil || offset != offsets[n] {
+			t.Error("Expected time of record", n, "at", offsets[n], "Got", offset, err)
+		}
+	}
+	if offset, _ := l.SeekTime(start.Add(time.Hour)); offset != l.Size() {
+		t.Error("Expected the end of the log. Got", offset)
+	}
+}
@@ -0,0 +1,82 @@
+package appender
+
+import (
+	"errors"
+	"iter"
+)
+
+// Stop can be returned by a WalkFunc to end the walk early without error.
+var Stop = errors.New("appender: stop iteration")
+
+// Entry is an entry read from a file.
+type Entry struct {
+	Offset int64 // Where its record starts. The entries of a batch share it.
+	Next   int64 // Where the following record starts
+	Meta   Meta  // Zero if the record was written without it
+	Data   []byte
+}
+
+// WalkFunc is called for every entry. Returning an error ends the walk and
+// the error is returned to the caller, unless it is Stop.
+type WalkFunc func(e Entry) error
+
+// Walk calls fn for every entry of the file until fn returns an error.
+func (f *File) Walk(fn WalkFunc) error {
+	return f.Snapshot().Walk(fn)
+}
+
+// WalkFrom is like Walk but starts at the record found at offset. See
+// Reader.WalkFrom.
+func (f *File) WalkFrom(offset int64, fn WalkFunc) (next int64, err error) {
+	return f.Snapshot().WalkFrom(offset, fn)
+}
+
+// Records returns an iterator over the entries of the file:
+//
+//	for e, err := range f.Records() {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// A read error is yielded once, as the last value.
+func (f *File) Records() iter.Seq2[Entry, error] {
+	return f.RecordsFrom(0)
+}
+
+// RecordsFrom is like Records but starts at the record found at offset.
+func (f *File) RecordsFrom(offset int64) iter.Seq2[Entry, error] {
+	return records(func(fn WalkFunc) (int64, error) {
+		return f.Snapshot().walk(offset, nil, fn)
+	})
+}
+
+// Records returns an iterator over the entries of the log. See
+// File.Records.
+func (l *Log) Records() iter.Seq2[Entry, error] {
+	return records(func(fn WalkFunc) (int64, error) {
+		return l.walkFrom(0, true, fn)
+	})
+}
+
+// RecordsFrom is like Records but starts at the record found at offset.
+func (l *Log) RecordsFrom(offset int64) iter.Seq2[Entry, error] {
+	return records(func(fn WalkFunc) (int64, error) {
+		return l.WalkFrom(offset, fn)
+	})
+}
+
+func records(walk func(WalkFunc) (int64, error)) iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		_, err := walk(func(e Entry) error {
+			if !yield(e, nil) {
+				return Stop
+			}
+			return nil
+		})
+		if err != nil && err != Stop {
+			yield(Entry{}, err)
+		}
+	}
+}
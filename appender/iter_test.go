@@ -0,0 +1,105 @@
+package appender
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	db := &DB{}
+	db.Remove("user_walk")
+	defer db.Remove("user_walk")
+
+	f, err := db.Open("user_walk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	WriteAll(f, []string{"hello", "world", "el", "mundo"})
+
+	// Stop early
+	dataRead := []string{}
+	var last Entry
+	err = f.Walk(func(e Entry) error {
+		dataRead = append(dataRead, string(e.Data))
+		last = e
+		if len(dataRead) == 2 {
+			return Stop
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(dataRead, []string{"hello", "world"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resume from the next record
+	dataRead = dataRead[:0]
+	f.WalkFrom(last.Next, func(e Entry) error {
+		dataRead = append(dataRead, string(e.Data))
+		return nil
+	})
+	if err = Compare(dataRead, []string{"el", "mundo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors are given back
+	failure := errors.New("failure")
+	next, err := f.WalkFrom(0, func(e Entry) error {
+		if string(e.Data) == "el" {
+			return failure
+		}
+		return nil
+	})
+	if err != failure {
+		t.Fatal("Expected the error of the callback. Got", err)
+	}
+	if data, _ := f.ReadEntry(next); string(data) != "el" {
+		t.Error("Expected to stop at the failed record. Got", string(data))
+	}
+}
+
+func TestRecords(t *testing.T) {
+	db := &DB{SegmentSize: 2 * (headerSize + 5)}
+	db.RemoveLog("log_records")
+	defer db.RemoveLog("log_records")
+
+	l, err := db.OpenLog("log_records")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	sample := []string{"00000", "11111", "22222", "33333", "44444"}
+	WriteAllLog(l, sample)
+
+	dataRead := []string{}
+	for e, err := range l.Records() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := l.ReadEntry(e.Offset); string(data) != string(e.Data) {
+			t.Error("Entry offset does not match", e.Offset)
+		}
+		dataRead = append(dataRead, string(e.Data))
+		if len(dataRead) == 3 {
+			break
+		}
+	}
+	if err = Compare(dataRead, sample[:3]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Errors are yielded
+	count := 0
+	for _, err := range l.RecordsFrom(1) {
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		count++
+	}
+	if count != 1 {
+		t.Error("Expected just the error. Got", count)
+	}
+}
@@ -0,0 +1,320 @@
+// Package kv is a key/value store kept in appender files, in the way of
+// Bitcask. Every Put and Delete is appended to the current data file, and an
+// in-memory keydir maps every key to the record holding its value, so a Get
+// is a single read. The keydir is rebuilt when the store is opened, from the
+// hint files written by Merge or by scanning the data files.
+//
+// Old values and deleted keys keep using space until Merge rewrites the live
+// keys into a fresh file.
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/guillermo/go/appender"
+)
+
+var (
+	NotFound = errors.New("kv: key not found")
+	Closed   = errors.New("kv: store closed")
+)
+
+// DefaultMaxFileSize is the MaxFileSize of a Store that does not set it.
+const DefaultMaxFileSize = 256 << 20
+
+// Records are written with a Meta holding the key, whose Type tells what
+// they are. The data of a put is the value; a delete has no data.
+const (
+	typePut = 1 + iota
+	typeDelete
+)
+
+// Files are named after a sequence number, so the keydir is rebuilt reading
+// them in the order they were written.
+const (
+	dataPrefix = "data_"
+	hintPrefix = "hint_"
+)
+
+func dataName(id uint64) string {
+	return fmt.Sprintf("%s%020d", dataPrefix, id)
+}
+
+func hintName(id uint64) string {
+	return fmt.Sprintf("%s%020d", hintPrefix, id)
+}
+
+// Store is a key/value store. It is safe to use from several goroutines.
+type Store struct {
+	// MaxFileSize starts a new data file once the current one reaches it.
+	// Zero means DefaultMaxFileSize. It must be set before writing.
+	MaxFileSize int64
+
+	db     *appender.DB
+	m      sync.RWMutex // Protects everything below
+	files  []*dataFile  // In the order they were written, the active one last
+	next   uint64       // ID of the next file
+	keydir map[string]location
+	dead   int64 // Records holding values that were overwritten or deleted
+
+	mm   sync.Mutex // Serializes merges
+	stop chan struct{}
+}
+
+type dataFile struct {
+	id uint64
+	f  *appender.File
+}
+
+// location is where the current value of a key is stored.
+type location struct {
+	file   *dataFile
+	offset int64
+}
+
+// Open opens the store kept in db, creating it if needed. The options of db,
+// like its codec, keys or sync policy, apply to the records of the store.
+// The Root of db must be used by this store only. Keys are written as the
+// Meta of the records, so they are not encrypted.
+func Open(db *appender.DB) (*Store, error) {
+	root := db.Root
+	if root == "" {
+		root = "."
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	hints := map[uint64]bool{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if id, ok := parseName(e.Name(), dataPrefix); ok {
+			ids = append(ids, id)
+		} else if id, ok := parseName(e.Name(), hintPrefix); ok {
+			hints[id] = true
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	s := &Store{db: db, keydir: map[string]location{}, next: 1}
+	for _, id := range ids {
+		f, err := db.Open(dataName(id))
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		file := &dataFile{id: id, f: f}
+		s.files = append(s.files, file)
+		s.next = id + 1
+		if hints[id] && s.loadHint(file) == nil {
+			continue
+		}
+		if err := s.load(file); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	if len(s.files) == 0 {
+		if err := s.roll(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// parseName returns the ID of a file name starting with prefix.
+func parseName(name, prefix string) (uint64, bool) {
+	if !strings.HasPrefix(name, prefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(name, prefix), 10, 64)
+	return id, err == nil
+}
+
+// load adds the records of the data file to the keydir.
+func (s *Store) load(file *dataFile) error {
+	_, err := file.f.Snapshot().Scan(0, func(info appender.RecordInfo) error {
+		key := string(info.Meta.Key)
+		switch info.Meta.Type {
+		case typePut:
+			s.set(key, location{file: file, offset: info.Offset})
+		case typeDelete:
+			if _, ok := s.keydir[key]; ok {
+				delete(s.keydir, key)
+				s.dead++
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// set points key to loc, counting the value it replaces as dead. s.m must
+// be held.
+func (s *Store) set(key string, loc location) {
+	if _, ok := s.keydir[key]; ok {
+		s.dead++
+	}
+	s.keydir[key] = loc
+}
+
+// roll starts a new active file. s.m must be held.
+func (s *Store) roll() error {
+	f, err := s.db.Open(dataName(s.next))
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, &dataFile{id: s.next, f: f})
+	s.next++
+	return nil
+}
+
+// active returns the file to write to, rolling to a new one if the current
+// one is full. s.m must be held.
+func (s *Store) active() (*dataFile, error) {
+	if s.files == nil {
+		return nil, Closed
+	}
+	max := s.MaxFileSize
+	if max <= 0 {
+		max = DefaultMaxFileSize
+	}
+	if s.files[len(s.files)-1].f.Size() >= max {
+		if err := s.roll(); err != nil {
+			return nil, err
+		}
+	}
+	return s.files[len(s.files)-1], nil
+}
+
+// Get returns the value of key, or NotFound.
+func (s *Store) Get(key []byte) ([]byte, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	loc, ok := s.keydir[string(key)]
+	if !ok {
+		return nil, NotFound
+	}
+	return loc.file.f.ReadEntry(loc.offset)
+}
+
+// Put sets the value of key. Keys can be up to 64KiB long.
+func (s *Store) Put(key, value []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	file, err := s.active()
+	if err != nil {
+		return err
+	}
+	offset, err := file.f.AppendMeta(appender.Meta{Type: typePut, Key: key}, value)
+	if err != nil {
+		return err
+	}
+	s.set(string(key), location{file: file, offset: offset})
+	return nil
+}
+
+// Delete removes key. Deleting a key that is not found does nothing.
+func (s *Store) Delete(key []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, ok := s.keydir[string(key)]; !ok {
+		return nil
+	}
+	file, err := s.active()
+	if err != nil {
+		return err
+	}
+	if _, err := file.f.AppendMeta(appender.Meta{Type: typeDelete, Key: key}, nil); err != nil {
+		return err
+	}
+	delete(s.keydir, string(key))
+	s.dead++
+	return nil
+}
+
+// Len returns the number of keys in the store.
+func (s *Store) Len() int {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return len(s.keydir)
+}
+
+// Keys calls fn for every key of the store, in no particular order, until
+// fn returns false. The store must not be written from fn.
+func (s *Store) Keys(fn func(key []byte) bool) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	for key := range s.keydir {
+		if !fn([]byte(key)) {
+			return
+		}
+	}
+}
+
+// Close stops the merger, waits for a merge in progress and closes the
+// files of the store.
+func (s *Store) Close() (err error) {
+	s.StopMerger()
+	s.mm.Lock()
+	defer s.mm.Unlock()
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, file := range s.files {
+		if cerr := file.f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	s.files = nil
+	return err
+}
+
+// StartMerger runs Merge every interval, when there are dead records, until
+// StopMerger is called.
+func (s *Store) StartMerger(interval time.Duration) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	s.stop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.m.RLock()
+				dead := s.dead
+				s.m.RUnlock()
+				if dead > 0 {
+					s.Merge()
+				}
+			}
+		}
+	}()
+}
+
+// StopMerger stops the merger started by StartMerger.
+func (s *Store) StopMerger() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
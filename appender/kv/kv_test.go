@@ -0,0 +1,91 @@
+package kv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/guillermo/go/appender"
+)
+
+func openStore(t *testing.T, root string) *Store {
+	s, err := Open(&appender.DB{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func expect(t *testing.T, s *Store, key, value string) {
+	t.Helper()
+	got, err := s.Get([]byte(key))
+	if err != nil {
+		t.Fatal("Expected", value, "for", key, "Got", err)
+	}
+	if string(got) != value {
+		t.Error("Expected", value, "for", key, "Got", string(got))
+	}
+}
+
+func TestStore(t *testing.T) {
+	root := t.TempDir()
+	s := openStore(t, root)
+	s.Put([]byte("a"), []byte("1"))
+	s.Put([]byte("b"), []byte("2"))
+	s.Put([]byte("a"), []byte("3"))
+	s.Delete([]byte("b"))
+	s.Delete([]byte("missing"))
+
+	expect(t, s, "a", "3")
+	if _, err := s.Get([]byte("b")); err != NotFound {
+		t.Error("Expected NotFound. Got", err)
+	}
+	if s.Len() != 1 {
+		t.Error("Expected 1 key. Got", s.Len())
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put([]byte("a"), nil); err != Closed {
+		t.Error("Expected Closed. Got", err)
+	}
+
+	// The keydir is rebuilt from the data files
+	s = openStore(t, root)
+	defer s.Close()
+	expect(t, s, "a", "3")
+	if _, err := s.Get([]byte("b")); err != NotFound {
+		t.Error("Expected NotFound. Got", err)
+	}
+	if s.dead != 2 {
+		t.Error("Expected 2 dead records. Got", s.dead)
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	root := t.TempDir()
+	s := openStore(t, root)
+	s.MaxFileSize = 100
+	for i := 0; i < 20; i++ {
+		if err := s.Put([]byte(fmt.Sprint("key", i)), []byte(fmt.Sprint("value", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.files) < 2 {
+		t.Error("Expected several data files. Got", len(s.files))
+	}
+	s.Close()
+
+	s = openStore(t, root)
+	defer s.Close()
+	for i := 0; i < 20; i++ {
+		expect(t, s, fmt.Sprint("key", i), fmt.Sprint("value", i))
+	}
+	keys := 0
+	s.Keys(func(key []byte) bool {
+		keys++
+		return true
+	})
+	if keys != 20 {
+		t.Error("Expected 20 keys. Got", keys)
+	}
+}
@@ -0,0 +1,156 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/guillermo/go/appender"
+)
+
+// The hint file of a merged data file lists its keys: a record per key with
+// the key in its Meta and the offset of the value as an int64 little endian.
+// It ends with a checkpoint holding the size of the data file, so a hint
+// that was not completely written, or that does not match its file, is
+// never used.
+var staleHint = errors.New("kv: stale hint file")
+
+// Merge copies the live values of every data file into a new one, with its
+// hint file, and removes the old files, dropping overwritten values and
+// deleted keys. Reads and writes go on meanwhile, to a new active file.
+//
+// If the process stops half way, the files copied so far are kept together
+// with the old ones, which are read first when the store is opened again.
+func (s *Store) Merge() error {
+	s.mm.Lock()
+	defer s.mm.Unlock()
+
+	s.m.Lock()
+	if s.files == nil {
+		s.m.Unlock()
+		return Closed
+	}
+	inputs := append([]*dataFile(nil), s.files...)
+	dead := s.dead
+	merged, err := s.db.Open(dataName(s.next))
+	if err != nil {
+		s.m.Unlock()
+		return err
+	}
+	out := &dataFile{id: s.next, f: merged}
+	s.files = append(s.files, out)
+	s.next++
+	// Nothing else is written to the inputs
+	err = s.roll()
+	s.m.Unlock()
+	if err != nil {
+		return err
+	}
+
+	hint, err := s.db.Open(hintName(out.id))
+	if err != nil {
+		return err
+	}
+	defer hint.Close()
+	for _, in := range inputs {
+		if err := s.copyLive(in, out, hint); err != nil {
+			return err
+		}
+	}
+	if err := merged.Sync(); err != nil {
+		return err
+	}
+	size := binary.LittleEndian.AppendUint64(nil, uint64(merged.Size()))
+	if _, err := hint.Checkpoint(size); err != nil {
+		return err
+	}
+	if err := hint.Sync(); err != nil {
+		return err
+	}
+
+	// Every key now points past the inputs
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.files = s.files[len(inputs):]
+	s.dead -= dead
+	for _, in := range inputs {
+		if cerr := in.f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if rerr := s.db.Remove(dataName(in.id)); rerr != nil && err == nil {
+			err = rerr
+		}
+		s.db.Remove(hintName(in.id))
+	}
+	return err
+}
+
+// copyLive appends to out the values of in that are still current, moving
+// their keys to out.
+func (s *Store) copyLive(in, out *dataFile, hint *appender.File) error {
+	return in.f.Walk(func(e appender.Entry) error {
+		if e.Meta.Type != typePut {
+			return nil
+		}
+		key := string(e.Meta.Key)
+		old := location{file: in, offset: e.Offset}
+		s.m.RLock()
+		live := s.keydir[key] == old
+		s.m.RUnlock()
+		if !live {
+			return nil
+		}
+
+		offset, err := out.f.AppendMeta(e.Meta, e.Data)
+		if err != nil {
+			return err
+		}
+		entry := binary.LittleEndian.AppendUint64(nil, uint64(offset))
+		if _, err := hint.AppendMeta(appender.Meta{Key: e.Meta.Key}, entry); err != nil {
+			return err
+		}
+
+		s.m.Lock()
+		// It may have been written again while copying
+		if s.keydir[key] == old {
+			s.keydir[key] = location{file: out, offset: offset}
+		}
+		s.m.Unlock()
+		return nil
+	})
+}
+
+// loadHint adds the keys listed in the hint file of a merged data file to
+// the keydir, instead of reading the file itself.
+func (s *Store) loadHint(file *dataFile) error {
+	hint, err := s.db.Open(hintName(file.id))
+	if err != nil {
+		return err
+	}
+	defer hint.Close()
+	c, err := hint.LastCheckpoint()
+	if err != nil {
+		return err
+	}
+	if len(c.Data) != 8 || int64(binary.LittleEndian.Uint64(c.Data)) != file.f.Size() {
+		return staleHint
+	}
+
+	keys := map[string]int64{}
+	err = hint.Walk(func(e appender.Entry) error {
+		if e.Offset > c.Offset {
+			return appender.Stop
+		}
+		if len(e.Data) != 8 {
+			return staleHint
+		}
+		keys[string(e.Meta.Key)] = int64(binary.LittleEndian.Uint64(e.Data))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for key, offset := range keys {
+		s.set(key, location{file: file, offset: offset})
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package kv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	root := t.TempDir()
+	s := openStore(t, root)
+	s.MaxFileSize = 100
+	for i := 0; i < 30; i++ {
+		s.Put([]byte(fmt.Sprint("key", i%10)), []byte(fmt.Sprint("value", i)))
+	}
+	s.Delete([]byte("key0"))
+
+	if err := s.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.files) != 2 {
+		t.Error("Expected the merged file and the active one. Got", len(s.files))
+	}
+	if s.dead != 0 {
+		t.Error("Expected no dead records. Got", s.dead)
+	}
+	for i := 1; i < 10; i++ {
+		expect(t, s, fmt.Sprint("key", i), fmt.Sprint("value", 20+i))
+	}
+	if _, err := s.Get([]byte("key0")); err != NotFound {
+		t.Error("Expected NotFound. Got", err)
+	}
+	merged := s.files[0].id
+	s.Close()
+
+	if _, err := os.Stat(filepath.Join(root, hintName(merged))); err != nil {
+		t.Error("Expected a hint file", err)
+	}
+	s = openStore(t, root)
+	defer s.Close()
+	for i := 1; i < 10; i++ {
+		expect(t, s, fmt.Sprint("key", i), fmt.Sprint("value", 20+i))
+	}
+	if s.Len() != 9 {
+		t.Error("Expected 9 keys. Got", s.Len())
+	}
+}
+
+func TestStaleHint(t *testing.T) {
+	root := t.TempDir()
+	s := openStore(t, root)
+	s.Put([]byte("a"), []byte("1"))
+	s.Put([]byte("a"), []byte("2"))
+	if err := s.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	merged := s.files[0]
+	s.Close()
+
+	// Wrong offsets, but they are for a size the file does not have
+	hint, err := s.db.Open(hintName(merged.id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hint.Checkpoint([]byte("12345678"))
+	hint.Close()
+
+	s = openStore(t, root)
+	defer s.Close()
+	expect(t, s, "a", "2")
+}
+
+func TestMergeConcurrent(t *testing.T) {
+	s := openStore(t, t.TempDir())
+	defer s.Close()
+	s.MaxFileSize = 1024
+	s.StartMerger(time.Millisecond)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := []byte(fmt.Sprint("key", w, i%10))
+				if err := s.Put(key, []byte(fmt.Sprint(i))); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := s.Get(key); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	s.StopMerger()
+	if err := s.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	for w := 0; w < 4; w++ {
+		for i := 190; i < 200; i++ {
+			expect(t, s, fmt.Sprint("key", w, i%10), fmt.Sprint(i))
+		}
+	}
+}
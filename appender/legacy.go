@@ -0,0 +1,110 @@
+package appender
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// The first versions of the package wrote every record as an int64 little
+// endian length followed by the data, with no checksum nor file header.
+// Their files are told apart from the headerless files written with
+// checksums because their first record does not match its checksum, and
+// their lengths add up exactly to the size of the file. The length and the
+// data were written separately, so the last record may have been cut short
+// by a crash: a file whose last length is complete, within the maximum
+// record size, but goes past its end is taken for one too.
+//
+// They are migrated when opened for writing: every record is framed again
+// with its checksum, so unlike the files migrated from version 0 their
+// offsets change. The first versions did not have offsets. A record cut
+// short is dropped if DB.Repair is set, and fails the migration otherwise.
+const legacyHeaderSize = 8
+
+// isLegacy tells if the size bytes of r are records without checksums, the
+// last one maybe cut short. Records holding more than max bytes are not.
+func isLegacy(r io.ReaderAt, size, max int64) (bool, error) {
+	buf := bufio.NewReader(io.NewSectionReader(r, 0, size))
+	var hdr [legacyHeaderSize]byte
+	for offset := int64(0); offset < size; {
+		if size-offset < legacyHeaderSize {
+			return false, nil
+		}
+		if _, err := io.ReadFull(buf, hdr[:]); err != nil {
+			return false, err
+		}
+		n := int64(binary.LittleEndian.Uint64(hdr[:]))
+		if n < 0 || n > max {
+			return false, nil
+		}
+		if n > size-offset-legacyHeaderSize {
+			// Cut short
+			return true, nil
+		}
+		if _, err := buf.Discard(int(n)); err != nil {
+			return false, err
+		}
+		offset += legacyHeaderSize + n
+	}
+	return true, nil
+}
+
+// readLegacy calls fn with the data of every record of the size bytes of r,
+// written without checksums. Records holding more than max bytes are not
+// read.
+func readLegacy(r io.ReaderAt, size, max int64, fn func(data []byte) error) error {
+	buf := bufio.NewReader(io.NewSectionReader(r, 0, size))
+	var hdr [legacyHeaderSize]byte
+	for offset := int64(0); offset < size; {
+		if size-offset < legacyHeaderSize {
+			return &CorruptionError{Offset: offset, Reason: "truncated header", short: true}
+		}
+		if _, err := io.ReadFull(buf, hdr[:]); err != nil {
+			return err
+		}
+		n := int64(binary.LittleEndian.Uint64(hdr[:]))
+		if n > max {
+			return &RecordTooLargeError{Offset: offset, Size: n, Max: max}
+		}
+		if n < 0 {
+			return &CorruptionError{Offset: offset, Reason: "negative length"}
+		}
+		if n > size-offset-legacyHeaderSize {
+			return &CorruptionError{Offset: offset, Reason: "length out of range", short: true}
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(buf, data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+		offset += legacyHeaderSize + n
+	}
+	return nil
+}
+
+// migrateLegacy rewrites the file at path, written without checksums, in the
+// current format. A record cut short is dropped if repair is set.
+func (db *DB) migrateLegacy(path string, repair bool) error {
+	old, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	info, err := old.Stat()
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, 0, func(w io.Writer) error {
+		err := readLegacy(old, info.Size(), db.maxRecordSize(), func(data []byte) error {
+			_, err := w.Write(encode(0, data))
+			return err
+		})
+		if cerr, ok := err.(*CorruptionError); ok && cerr.short && repair {
+			return nil
+		}
+		return err
+	})
+}
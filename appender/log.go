@@ -0,0 +1,372 @@
+package appender
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const segmentExt = ".log"
+
+// Log is a file split in segments stored in its own directory. Records are
+// written to the last segment, and a new one is started when DB.SegmentSize
+// or DB.SegmentAge is reached.
+//
+// Offsets keep growing across segments: every segment is named after the
+// offset of its first byte, so an offset returned by Append is valid for the
+// whole life of the log. There may be gaps between segments, where Compact
+// dropped records.
+type Log struct {
+	db   *DB
+	dir  string
+	m    sync.Mutex // Serializes writes and protects segs
+	cm   sync.Mutex // Serializes compactions
+	segs []*segment
+
+	// Protected by cm, see Compact
+	compacted    int64     // Base of the segment being written at the last compaction
+	tombstoneDue time.Time // When the first tombstone it kept can be dropped
+}
+
+type segment struct {
+	*File
+	base     int64
+	created  time.Time
+	refs     int  // Readers using the segment
+	doomed   bool // Remove once the last reader is done
+	replaced bool // Its file was replaced by Compact, only close it
+}
+
+func segmentName(base int64) string {
+	return fmt.Sprintf("%020d%s", base, segmentExt)
+}
+
+// OpenLog opens the log stored in the directory name, creating it if needed.
+func (db *DB) OpenLog(name string) (*Log, error) {
+	dir, err := db.path(name)
+	if err != nil {
+		return nil, err
+	}
+	db.om.RLock()
+	defer db.om.RUnlock()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	l := &Log{db: db, dir: dir}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var compacted []int64 // Bases of the segments left by Compact
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), rewriteExt) {
+			// Left by a TruncateBefore or Compact that did not finish
+			os.Remove(filepath.Join(dir, e.Name()))
+			continue
+		}
+		if !e.IsDir() && strings.HasSuffix(e.Name(), segmentExt+compactExt) {
+			if base, err := strconv.ParseInt(strings.TrimSuffix(e.Name(), segmentExt+compactExt), 10, 64); err == nil {
+				compacted = append(compacted, base)
+			}
+			continue
+		}
+		if e.IsDir() || !strings.HasSuffix(e.Name(), segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseInt(strings.TrimSuffix(e.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		f, err := db.openFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		// The creation time is not kept on disk, so after a restart the age
+		// of a segment counts from its last modification.
+		l.segs = append(l.segs, &segment{File: f, base: base, created: info.ModTime()})
+	}
+	sort.Slice(l.segs, func(i, j int) bool { return l.segs[i].base < l.segs[j].base })
+
+	if err := l.recoverCompact(compacted); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	// A segment reaching past the beginning of the next one was replaced by
+	// TruncateBefore, which stopped before removing it.
+	for i := 0; i+1 < len(l.segs); {
+		if s := l.segs[i]; s.base+s.Size() > l.segs[i+1].base {
+			l.segs = append(l.segs[:i], l.segs[i+1:]...)
+			s.remove()
+			continue
+		}
+		i++
+	}
+
+	if len(l.segs) == 0 {
+		if err := l.roll(0); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	db.register(l)
+	return l, nil
+}
+
+// RemoveLog removes a log and all its segments.
+func (db *DB) RemoveLog(name string) error {
+	dir, err := db.path(name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// roll starts a new segment at base. l.m must be held.
+func (l *Log) roll(base int64) error {
+	f, err := l.db.openFile(filepath.Join(l.dir, segmentName(base)))
+	if err != nil {
+		return err
+	}
+	l.segs = append(l.segs, &segment{File: f, base: base, created: time.Now()})
+	return nil
+}
+
+// active returns the segment the next record of size bytes must go to,
+// rolling to a new one if needed. l.m must be held.
+func (l *Log) active(size int64) (*segment, error) {
+	s := l.segs[len(l.segs)-1]
+	current := s.Size()
+	if current == 0 {
+		return s, nil
+	}
+	full := l.db.SegmentSize > 0 && current+size > l.db.SegmentSize
+	old := l.db.SegmentAge > 0 && time.Since(s.created) >= l.db.SegmentAge
+	if !full && !old {
+		return s, nil
+	}
+	if err := l.roll(s.base + current); err != nil {
+		return nil, err
+	}
+	return l.segs[len(l.segs)-1], nil
+}
+
+// Write at the end data into the log
+func (l *Log) Write(data []byte) (n int, err error) {
+	if _, err = l.Append(data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Append writes data at the end of the log and returns its offset.
+func (l *Log) Append(data []byte) (offset int64, err error) {
+	record, err := l.db.frame(0, nil, data)
+	if err != nil {
+		return 0, err
+	}
+	return l.append(record)
+}
+
+// append writes a framed record to the active segment.
+func (l *Log) append(record []byte) (offset int64, err error) {
+	l.m.Lock()
+	s, err := l.active(int64(len(record)))
+	if err == nil {
+		offset, err = s.write(record, 1)
+	}
+	l.m.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	// Wait outside the lock so concurrent writers can share a flush
+	if err = s.durable(offset + int64(len(record))); err != nil {
+		return 0, err
+	}
+	return s.base + offset, nil
+}
+
+// segments returns a copy of the current list of segments.
+func (l *Log) segments() []*segment {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return append([]*segment(nil), l.segs...)
+}
+
+// acquire is like segments, but the segments returned are not removed from
+// disk until they are given back to release.
+func (l *Log) acquire() []*segment {
+	l.m.Lock()
+	defer l.m.Unlock()
+	for _, s := range l.segs {
+		s.refs++
+	}
+	return append([]*segment(nil), l.segs...)
+}
+
+func (l *Log) release(segs []*segment) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	for _, s := range segs {
+		s.refs--
+		if s.refs == 0 && s.doomed {
+			s.remove()
+		}
+	}
+}
+
+// drop takes the first segment out of the log. Its file is removed as soon
+// as nobody is reading it. l.m must be held.
+func (l *Log) drop() *segment {
+	s := l.segs[0]
+	l.segs = l.segs[1:]
+	s.doom()
+	return s
+}
+
+// doom removes the file of a segment taken out of the log as soon as nobody
+// is reading it. l.m must be held.
+func (s *segment) doom() {
+	s.doomed = true
+	if s.refs == 0 {
+		s.remove()
+	}
+}
+
+func (s *segment) remove() {
+	s.Close()
+	if s.replaced {
+		return
+	}
+	os.Remove(s.name + indexExt)
+	os.Remove(s.name)
+}
+
+// Size returns the offset where the next record will be written.
+func (l *Log) Size() int64 {
+	segs := l.segments()
+	last := segs[len(segs)-1]
+	return last.base + last.Size()
+}
+
+// ReadEntry returns the data of the record that starts at offset.
+func (l *Log) ReadEntry(offset int64) ([]byte, error) {
+	segs := l.acquire()
+	defer l.release(segs)
+	for i := len(segs) - 1; i >= 0; i-- {
+		if segs[i].base <= offset {
+			if i < len(segs)-1 && offset-segs[i].base >= segs[i].Size() {
+				// A gap left by Compact
+				return nil, Compacted
+			}
+			return segs[i].ReadEntry(offset - segs[i].base)
+		}
+	}
+	return nil, OffsetOutOfRange
+}
+
+// Iterate walks all the records of all the segments in order.
+func (l *Log) Iterate(iterator Iterator) error {
+	_, err := l.walkFrom(0, true, func(e Entry) error {
+		iterator(bytes.NewReader(e.Data))
+		return nil
+	})
+	return err
+}
+
+// First returns the offset of the oldest record kept in the log.
+func (l *Log) First() int64 {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.segs[0].base
+}
+
+// IterateFrom is like Iterate but starts at the record found at offset. It
+// returns the offset following the last record read.
+func (l *Log) IterateFrom(offset int64, iterator Iterator) (next int64, err error) {
+	return l.WalkFrom(offset, func(e Entry) error {
+		iterator(bytes.NewReader(e.Data))
+		return nil
+	})
+}
+
+// Walk calls fn for every entry of the log until fn returns an error.
+func (l *Log) Walk(fn WalkFunc) error {
+	_, err := l.walkFrom(0, true, fn)
+	return err
+}
+
+// WalkFrom is like Walk but starts at the record found at offset. See
+// Reader.WalkFrom.
+func (l *Log) WalkFrom(offset int64, fn WalkFunc) (next int64, err error) {
+	return l.walkFrom(offset, false, fn)
+}
+
+// walkFrom walks from offset, or from the oldest record kept if fromFirst.
+func (l *Log) walkFrom(offset int64, fromFirst bool, fn WalkFunc) (next int64, err error) {
+	segs := l.acquire()
+	defer l.release(segs)
+	if fromFirst {
+		offset = segs[0].base
+	}
+	next, err = l.walk(segs, offset, nil, fn)
+	if err == Stop {
+		err = nil
+	}
+	return next, err
+}
+
+func (l *Log) walk(segs []*segment, offset int64, match func(Meta) bool, fn WalkFunc) (next int64, err error) {
+	last := segs[len(segs)-1]
+	if offset < segs[0].base || offset > last.base+last.Size() {
+		return offset, OffsetOutOfRange
+	}
+	for _, s := range segs {
+		local := offset - s.base
+		if local < 0 {
+			local = 0
+		}
+		if local >= s.Size() && s != last {
+			continue
+		}
+		base := s.base
+		local, err = s.Snapshot().walk(local, match, func(e Entry) error {
+			e.Offset += base
+			e.Next += base
+			return fn(e)
+		})
+		offset = base + local
+		if err != nil {
+			return offset, err
+		}
+	}
+	return offset, nil
+}
+
+// Close all the segments of the log.
+func (l *Log) Close() (err error) {
+	l.db.unregister(l)
+	l.m.Lock()
+	defer l.m.Unlock()
+	for _, s := range l.segs {
+		if cerr := s.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	l.segs = nil
+	return err
+}
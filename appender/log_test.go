@@ -0,0 +1,126 @@
+package appender
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func WriteAllLog(l *Log, tests []string) error {
+	for _, s := range tests {
+		if _, err := l.Write([]byte(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ReadAllLog(l *Log) (tests []string, err error) {
+	dataRead := []string{}
+	err = l.Iterate(func(entry io.Reader) {
+		readed, err := ioutil.ReadAll(entry)
+		if err != nil {
+			panic(err)
+		}
+		dataRead = append(dataRead, string(readed))
+	})
+	return dataRead, err
+}
+
+func TestLogSegments(t *testing.T) {
+	db := &DB{SegmentSize: 3 * (headerSize + 5)}
+	db.RemoveLog("log_segments")
+	defer db.RemoveLog("log_segments")
+
+	l, err := db.OpenLog("log_segments")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := []string{"00000", "11111", "22222", "33333", "44444", "55555", "66666"}
+	offsets := []int64{}
+	for _, s := range sample {
+		offset, err := l.Append([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(offsets) > 0 && offset <= offsets[len(offsets)-1] {
+			t.Fatal("Offsets should grow", offsets, offset)
+		}
+		offsets = append(offsets, offset)
+	}
+	l.Close()
+
+	files, _ := os.ReadDir("log_segments")
+	if len(files) != 3 {
+		t.Fatal("Expected 3 segments. Got", len(files))
+	}
+
+	l, err = db.OpenLog("log_segments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if err = WriteAllLog(l, []string{"77777"}); err != nil {
+		t.Fatal(err)
+	}
+	sample = append(sample, "77777")
+
+	data, err := ReadAllLog(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, sample); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, offset := range offsets {
+		data, err := l.ReadEntry(offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != sample[i] {
+			t.Error("Expected", sample[i], "at", offset, "Got", string(data))
+		}
+	}
+
+	dataRead := []string{}
+	next, err := l.IterateFrom(offsets[2], func(entry io.Reader) {
+		readed, _ := ioutil.ReadAll(entry)
+		dataRead = append(dataRead, string(readed))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(dataRead, sample[2:]); err != nil {
+		t.Fatal(err)
+	}
+	if next != l.Size() {
+		t.Error("Expected to stop at", l.Size(), "Got", next)
+	}
+}
+
+func TestLogSegmentAge(t *testing.T) {
+	db := &DB{SegmentAge: 10 * time.Millisecond}
+	db.RemoveLog("log_age")
+	defer db.RemoveLog("log_age")
+
+	l, err := db.OpenLog("log_age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Write([]byte("hello"))
+	l.Write([]byte("world"))
+	time.Sleep(20 * time.Millisecond)
+	l.Write([]byte("!"))
+
+	files, _ := os.ReadDir("log_age")
+	if len(files) != 2 {
+		t.Fatal("Expected 2 segments. Got", len(files))
+	}
+}
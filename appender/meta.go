@@ -0,0 +1,111 @@
+package appender
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// KeyTooLong is returned when writing a Meta with a key over 64KiB.
+var KeyTooLong = errors.New("appender: meta key too long")
+
+// Meta is optional information written with a record. It is stored in clear
+// before the data, so it can be read without decompressing or decrypting the
+// data (it is still authenticated when the record is encrypted).
+type Meta struct {
+	Time time.Time // When the record was appended
+	Type uint8     // Free for the application to use
+	Key  []byte    // Up to 64KiB
+}
+
+// A meta is written as the time in unix nanoseconds, the type and the key
+// preceded by its uint16 length.
+const metaSize = 8 + 1 + 2
+
+func (m *Meta) encode() ([]byte, error) {
+	if len(m.Key) > 0xffff {
+		return nil, KeyTooLong
+	}
+	t := m.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	buf := make([]byte, metaSize, metaSize+len(m.Key))
+	binary.LittleEndian.PutUint64(buf, uint64(t.UnixNano()))
+	buf[8] = m.Type
+	binary.LittleEndian.PutUint16(buf[9:], uint16(len(m.Key)))
+	return append(buf, m.Key...), nil
+}
+
+// splitMeta returns the meta at the beginning of the data of a record and
+// the data that follows it.
+func splitMeta(offset int64, flags byte, data []byte) (m Meta, body []byte, err error) {
+	if flags&flagMeta == 0 {
+		return m, data, nil
+	}
+	if len(data) < metaSize {
+		return m, nil, &CorruptionError{Offset: offset, Reason: "truncated meta"}
+	}
+	n := int(binary.LittleEndian.Uint16(data[9:]))
+	if len(data) < metaSize+n {
+		return m, nil, &CorruptionError{Offset: offset, Reason: "truncated meta"}
+	}
+	m.Time = time.Unix(0, int64(binary.LittleEndian.Uint64(data)))
+	m.Type = data[8]
+	if n > 0 {
+		m.Key = data[metaSize : metaSize+n]
+	}
+	return m, data[metaSize+n:], nil
+}
+
+// AppendMeta is like Append but writes meta with the data. A zero Time is
+// set to the current time.
+func (f *File) AppendMeta(meta Meta, data []byte) (offset int64, err error) {
+	record, err := f.db.frame(0, &meta, data)
+	if err != nil {
+		return 0, err
+	}
+	offset, err = f.write(record, 1)
+	if err != nil {
+		return 0, err
+	}
+	return offset, f.durable(offset + int64(len(record)))
+}
+
+// AppendMeta is like Append but writes meta with the data. See
+// File.AppendMeta.
+func (l *Log) AppendMeta(meta Meta, data []byte) (offset int64, err error) {
+	record, err := l.db.frame(0, &meta, data)
+	if err != nil {
+		return 0, err
+	}
+	return l.append(record)
+}
+
+// Filter walks the entries of the snapshot whose meta matches. The data of
+// the records that do not match is not decoded.
+func (r *Reader) Filter(match func(Meta) bool, fn WalkFunc) error {
+	_, err := r.walk(0, match, fn)
+	if err == Stop {
+		err = nil
+	}
+	return err
+}
+
+// Filter walks the entries of the file whose meta matches. See
+// Reader.Filter.
+func (f *File) Filter(match func(Meta) bool, fn WalkFunc) error {
+	return f.Snapshot().Filter(match, fn)
+}
+
+// Filter walks the entries of the log whose meta matches. See
+// Reader.Filter.
+func (l *Log) Filter(match func(Meta) bool, fn WalkFunc) error {
+	segs := l.acquire()
+	defer l.release(segs)
+	_, err := l.walk(segs, segs[0].base, match, fn)
+	if err == Stop {
+		err = nil
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package appender
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMeta(t *testing.T) {
+	db := &DB{Keys: Keys{1: bytes.Repeat([]byte{1}, 32)}, Codec: Flate}
+	db.Remove("user_meta")
+	defer db.Remove("user_meta")
+
+	f, err := db.Open("user_meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	before := time.Now()
+	f.AppendMeta(Meta{Type: 1, Key: []byte("user:1")}, []byte("hello"))
+	f.Write([]byte("no meta"))
+	db.Timestamps = true
+	f.Write([]byte("stamped"))
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.AppendMeta(Meta{Time: at, Type: 2}, []byte("world"))
+
+	entries := []Entry{}
+	if err = f.Walk(func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatal("Expected 4 entries. Got", len(entries))
+	}
+	if m := entries[0].Meta; m.Type != 1 || string(m.Key) != "user:1" || m.Time.Before(before) {
+		t.Error("Unexpected meta", m)
+	}
+	if m := entries[1].Meta; !m.Time.IsZero() || m.Key != nil {
+		t.Error("Expected no meta", m)
+	}
+	if m := entries[2].Meta; m.Time.Before(before) {
+		t.Error("Expected a timestamp", m)
+	}
+	if m := entries[3].Meta; !m.Time.Equal(at) || m.Type != 2 {
+		t.Error("Unexpected meta", m)
+	}
+
+	// Filtering by meta does not decode the data, so it works without keys
+	// as long as no encrypted record matches.
+	db.Keys = nil
+	f.AppendMeta(Meta{Type: 3}, []byte("clear"))
+	found := []string{}
+	err = f.Filter(func(m Meta) bool { return m.Time.IsZero() }, func(e Entry) error {
+		found = append(found, string(e.Data))
+		return nil
+	})
+	if err == nil {
+		t.Fatal("Expected an error decoding the record without meta")
+	}
+	found = found[:0]
+	err = f.Filter(func(m Meta) bool { return m.Type == 3 }, func(e Entry) error {
+		found = append(found, string(e.Data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(found, []string{"clear"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTamperedMeta(t *testing.T) {
+	db := &DB{Keys: Keys{1: bytes.Repeat([]byte{1}, 32)}}
+	db.Remove("user_meta_tamper")
+	defer db.Remove("user_meta_tamper")
+
+	f, err := db.Open("user_meta_tamper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.AppendMeta(Meta{Type: 1}, []byte("hello"))
+
+	// Change the type and fix the checksum
+	data, flags, _ := readRecord(f.section(0, f.Size()), 0, f.Size(), lengthMask)
+	data[8] = 2
+	f.f.Truncate(fileHeaderSize)
+	f.f.Write(encode(flags, data))
+
+	if err = f.Walk(func(e Entry) error { return nil }); err == nil {
+		t.Fatal("Expected the meta to be authenticated")
+	}
+}
@@ -0,0 +1,261 @@
+package appender
+
+// Mapping is a read only view of a File mapped in memory. The entries of
+// records that are neither compressed nor encrypted are slices of the
+// mapping: reading them makes no system calls and no copies, but they are
+// only valid until Close.
+//
+// Mappings are meant for sealed files that are read many times, like the
+// segments of a Log no longer written, see Log.Map. Like a
+// Reader, a Mapping only sees the records written before Map was called, so
+// records can still be appended. But the file can not be truncated below the
+// end of the mapping until it is closed: accessing mapped bytes that are no
+// longer in the file would crash the process.
+type Mapping struct {
+	f      *File
+	data   []byte // The records, after the file header
+	mapped []byte
+}
+
+// Map maps the records written so far in memory.
+func (f *File) Map() (*Mapping, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	size := f.Size()
+	if size == 0 {
+		return &Mapping{f: f}, nil
+	}
+	mapped, err := mmap(f.f, f.start+size)
+	if err != nil {
+		return nil, err
+	}
+	f.maps++
+	f.mapped = max(f.mapped, size)
+	return &Mapping{f: f, data: mapped[f.start:], mapped: mapped}, nil
+}
+
+// Close unmaps the file. Entries read from the mapping must not be used
+// afterwards.
+func (m *Mapping) Close() error {
+	if m.mapped == nil {
+		return nil
+	}
+	err := munmap(m.mapped)
+	m.data, m.mapped = nil, nil
+
+	f := m.f
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.maps--
+	if f.maps == 0 {
+		f.mapped = 0
+	}
+	return err
+}
+
+// Size returns the end of the last record seen by the mapping.
+func (m *Mapping) Size() int64 {
+	return int64(len(m.data))
+}
+
+// ReadEntry returns the data of the record that starts at offset.
+func (m *Mapping) ReadEntry(offset int64) ([]byte, error) {
+	data, flags, err := m.record(offset)
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagPadding != 0 {
+		return nil, Compacted
+	}
+	if flags&flagBatch != 0 {
+		return nil, BatchRecord
+	}
+	if flags&flagCheckpoint != 0 {
+		return nil, CheckpointRecord
+	}
+	_, list, err := m.f.db.decode(offset, flags, data)
+	if err != nil {
+		return nil, err
+	}
+	return list[0], nil
+}
+
+// ReadBatch returns the entries of the record that starts at offset. See
+// Reader.ReadBatch.
+func (m *Mapping) ReadBatch(offset int64) ([][]byte, error) {
+	data, flags, err := m.record(offset)
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagPadding != 0 {
+		return nil, Compacted
+	}
+	_, list, err := m.f.db.decode(offset, flags, data)
+	return list, err
+}
+
+func (m *Mapping) record(offset int64) (data []byte, flags byte, err error) {
+	if offset < 0 || offset >= m.Size() {
+		return nil, 0, OffsetOutOfRange
+	}
+	return parseRecord(m.data[offset:], offset, m.f.db.maxRecordSize())
+}
+
+// Walk calls fn for every entry of the mapping until fn returns an error.
+func (m *Mapping) Walk(fn WalkFunc) error {
+	_, err := m.WalkFrom(0, fn)
+	return err
+}
+
+// WalkFrom is like Walk but starts at the record found at offset. See
+// Reader.WalkFrom.
+func (m *Mapping) WalkFrom(offset int64, fn WalkFunc) (next int64, err error) {
+	next, err = m.walk(offset, fn)
+	if err == Stop {
+		err = nil
+	}
+	return next, err
+}
+
+// walk is WalkFrom returning Stop if fn does.
+func (m *Mapping) walk(offset int64, fn WalkFunc) (next int64, err error) {
+	if offset < 0 || offset > m.Size() {
+		return offset, OffsetOutOfRange
+	}
+	for offset < m.Size() {
+		data, flags, err := parseRecord(m.data[offset:], offset, m.f.db.maxRecordSize())
+		if err != nil {
+			return offset, err
+		}
+		end := offset + recordSize(int64(len(data)), flags)
+		if err := m.f.db.visit(offset, end, flags, data, nil, fn); err != nil {
+			return offset, err
+		}
+		offset = end
+	}
+	return offset, nil
+}
+
+// LogMapping is a read only view of the segments of a Log that are no
+// longer written, mapped in memory. See Mapping. The segments are kept until
+// Close, even if the retention policy or Compact drop them meanwhile.
+type LogMapping struct {
+	l     *Log
+	segs  []*segment // Acquired, the last one is the segment being written
+	maps  []*Mapping // Of every segment but the last
+	first int64
+	end   int64
+}
+
+// Map maps the segments of the log that are no longer written. The records
+// of the segment being written are not seen: they start at End.
+func (l *Log) Map() (*LogMapping, error) {
+	segs := l.acquire()
+	m := &LogMapping{l: l, segs: segs, first: segs[0].base, end: segs[len(segs)-1].base}
+	for _, s := range segs[:len(segs)-1] {
+		sm, err := s.Map()
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.maps = append(m.maps, sm)
+	}
+	return m, nil
+}
+
+// Close unmaps the segments and lets the log remove the ones it dropped.
+// Entries read from the mapping must not be used afterwards.
+func (m *LogMapping) Close() (err error) {
+	for _, sm := range m.maps {
+		if cerr := sm.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if m.segs != nil {
+		m.l.release(m.segs)
+	}
+	m.maps, m.segs = nil, nil
+	return err
+}
+
+// First returns the offset of the first record mapped.
+func (m *LogMapping) First() int64 {
+	return m.first
+}
+
+// End returns the offset following the last record mapped, where the
+// segment being written when Map was called starts.
+func (m *LogMapping) End() int64 {
+	return m.end
+}
+
+// ReadEntry returns the data of the record that starts at offset.
+func (m *LogMapping) ReadEntry(offset int64) ([]byte, error) {
+	sm, local, err := m.locate(offset)
+	if err != nil {
+		return nil, err
+	}
+	return sm.ReadEntry(local)
+}
+
+// ReadBatch returns the entries of the record that starts at offset. See
+// Reader.ReadBatch.
+func (m *LogMapping) ReadBatch(offset int64) ([][]byte, error) {
+	sm, local, err := m.locate(offset)
+	if err != nil {
+		return nil, err
+	}
+	return sm.ReadBatch(local)
+}
+
+// locate returns the mapping of the segment holding offset, and the offset
+// in it.
+func (m *LogMapping) locate(offset int64) (*Mapping, int64, error) {
+	if offset < m.first || offset >= m.end {
+		return nil, 0, OffsetOutOfRange
+	}
+	for i := len(m.maps) - 1; i >= 0; i-- {
+		if base := m.segs[i].base; base <= offset {
+			if offset-base >= m.maps[i].Size() {
+				// A gap left by Compact
+				return nil, 0, Compacted
+			}
+			return m.maps[i], offset - base, nil
+		}
+	}
+	return nil, 0, OffsetOutOfRange
+}
+
+// Walk calls fn for every entry of the mapping until fn returns an error.
+func (m *LogMapping) Walk(fn WalkFunc) error {
+	_, err := m.WalkFrom(m.first, fn)
+	return err
+}
+
+// WalkFrom is like Walk but starts at the record found at offset. See
+// Reader.WalkFrom.
+func (m *LogMapping) WalkFrom(offset int64, fn WalkFunc) (next int64, err error) {
+	if offset < m.first || offset > m.end {
+		return offset, OffsetOutOfRange
+	}
+	for i, sm := range m.maps {
+		base := m.segs[i].base
+		local := max(offset-base, 0)
+		if local >= sm.Size() {
+			continue
+		}
+		local, err = sm.walk(local, func(e Entry) error {
+			e.Offset += base
+			e.Next += base
+			return fn(e)
+		})
+		offset = base + local
+		if err == Stop {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+	}
+	return max(offset, m.end), nil
+}
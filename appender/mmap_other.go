@@ -0,0 +1,19 @@
+//go:build !linux && !darwin
+
+package appender
+
+import "os"
+
+// Without mmap the file is read in memory. Entries are still slices of it,
+// so the mapping behaves the same, only slower to create.
+func mmap(f *os.File, size int64) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, 0); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,223 @@
+package appender
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	db := &DB{}
+	db.Remove("user_map")
+	defer db.Remove("user_map")
+
+	f, err := db.Open("user_map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("hello"))
+	offset, _ := f.WriteBatch([][]byte{[]byte("el"), []byte("mundo")})
+	db.Codec = Flate
+	f.Write(make([]byte, 100))
+	db.Codec = nil
+
+	m, err := f.Map()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	f.Write([]byte("not mapped"))
+
+	data := []string{}
+	if err = m.Walk(func(e Entry) error {
+		data = append(data, string(e.Data))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"hello", "el", "mundo", string(make([]byte, 100))}); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry, err := m.ReadEntry(0); err != nil || string(entry) != "hello" {
+		t.Error("Expected hello. Got", string(entry), err)
+	}
+	if _, err = m.ReadEntry(offset); err != BatchRecord {
+		t.Error("Expected BatchRecord. Got", err)
+	}
+	if batch, err := m.ReadBatch(offset); err != nil || len(batch) != 2 {
+		t.Error("Unexpected batch", batch, err)
+	}
+	if _, err = m.ReadEntry(m.Size()); err != OffsetOutOfRange {
+		t.Error("Expected OffsetOutOfRange. Got", err)
+	}
+}
+
+func TestMapCorruption(t *testing.T) {
+	db := &DB{}
+	db.Remove("user_map_corrupt")
+	defer db.Remove("user_map_corrupt")
+
+	f, err := db.Open("user_map_corrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	WriteAll(f, []string{"hello", "world"})
+
+	// Flip a byte of the second record
+	w, err := os.OpenFile("user_map_corrupt", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteAt([]byte("W"), fileHeaderSize+2*headerSize+5)
+	w.Close()
+
+	m, err := f.Map()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	next, err := m.WalkFrom(0, func(e Entry) error { return nil })
+	if _, ok := err.(*CorruptionError); !ok || next != headerSize+5 {
+		t.Error("Expected a *CorruptionError at the second record. Got", next, err)
+	}
+}
+
+func TestMapTruncate(t *testing.T) {
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := db.Open("user_map_truncate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	WriteAll(f, []string{"hello"})
+	m, err := f.Map()
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := f.Size()
+	WriteAll(f, []string{"world"})
+
+	if err = f.Truncate(0); err != FileMapped {
+		t.Error("Expected FileMapped. Got", err)
+	}
+	// What is past the mapping can go
+	if err = f.Truncate(end); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Walk(func(e Entry) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Close()
+	if err = f.Truncate(0); err != nil {
+		t.Error("Expected to truncate once the mapping is closed. Got", err)
+	}
+}
+
+func BenchmarkIterate(b *testing.B) {
+	f := benchmarkFile(b)
+	defer f.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Iterate(func(entry io.Reader) {})
+	}
+}
+
+func BenchmarkWalk(b *testing.B) {
+	f := benchmarkFile(b)
+	defer f.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Walk(func(e Entry) error { return nil })
+	}
+}
+
+func BenchmarkWalkMapped(b *testing.B) {
+	f := benchmarkFile(b)
+	defer f.Close()
+	m, err := f.Map()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer m.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Walk(func(e Entry) error { return nil })
+	}
+}
+
+// benchmarkFile returns a file with 10000 small records.
+func benchmarkFile(b *testing.B) *File {
+	db, err := NewDB(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	f, err := db.Open("user_bench_read")
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := make([]byte, 32)
+	for i := 0; i < 10000; i++ {
+		f.Write(data)
+	}
+	return f
+}
+
+func TestLogMap(t *testing.T) {
+	db := &DB{Root: t.TempDir(), SegmentSize: 2 * (headerSize + 5), RetentionRecords: 1}
+	l, err := db.OpenLog("log_map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	WriteAllLog(l, []string{"00000", "11111", "22222", "33333", "44444"})
+
+	m, err := l.Map()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The segment being written is not mapped
+	if m.First() != 0 || m.End() != 4*(headerSize+5) {
+		t.Error("Unexpected range", m.First(), m.End())
+	}
+	first := l.segs[0].name
+	l.EnforceRetention()
+
+	data := []string{}
+	next, err := m.WalkFrom(headerSize+5, func(e Entry) error {
+		data = append(data, string(e.Data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"11111", "22222", "33333"}); err != nil {
+		t.Error(err)
+	}
+	if next != m.End() {
+		t.Error("Expected to stop at", m.End(), "Got", next)
+	}
+	if entry, err := m.ReadEntry(2 * (headerSize + 5)); err != nil || string(entry) != "22222" {
+		t.Error("Expected 22222. Got", string(entry), err)
+	}
+	if _, err = m.ReadEntry(m.End()); err != OffsetOutOfRange {
+		t.Error("Expected OffsetOutOfRange. Got", err)
+	}
+
+	// Segments dropped meanwhile are removed once unmapped
+	if _, err = os.Stat(first); err != nil {
+		t.Error("Expected the mapped segment to be kept")
+	}
+	if err = m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(first); !os.IsNotExist(err) {
+		t.Error("Expected the dropped segment to be removed")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin
+
+package appender
+
+import (
+	"os"
+	"syscall"
+)
+
+func mmap(f *os.File, size int64) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
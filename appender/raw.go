@@ -0,0 +1,119 @@
+package appender
+
+import (
+	"bufio"
+	"os"
+)
+
+// RecordInfo describes how a record is stored in a file. See Reader.Scan.
+type RecordInfo struct {
+	Offset     int64
+	Size       int64 // Bytes taken in the file, header and trailer included
+	DataSize   int64 // Bytes of data, after compression and encryption
+	Batch      bool
+	Compressed bool
+	Encrypted  bool
+	Trailer    bool
+	Checkpoint bool
+	Padding    bool // Left by Compact in place of a record it dropped
+	Tombstone  bool // See Log.AppendTombstone
+	Meta       Meta // Zero if the record was written without it
+}
+
+// OpenPath opens the file at path as it is. Unlike Open the path is not
+// escaped nor kept under Root, and the file is not shared: Close really
+// closes it. It is meant for tools working on files of any database.
+func (db *DB) OpenPath(path string) (*File, error) {
+	return db.openFile(path)
+}
+
+// OpenPathReadOnly is like OpenPath but never creates nor writes the file,
+// so it can be used to inspect files without permission to write them.
+// Appending to the file fails, and its index is not used. With DB.Repair, a
+// partial record at the end is not removed but ignored. Files written before
+// records had checksums can not be migrated, so they fail with LegacyFormat.
+func (db *DB) OpenPathReadOnly(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return db.initFile(f, path, db.Repair, false)
+}
+
+// Scan calls fn for every record from offset onwards, checking its framing
+// and checksum but without decoding its data, so it works on encrypted
+// records without the keys. It returns the offset following the last record
+// scanned, or the offset of the record that could not be read.
+func (r *Reader) Scan(offset int64, fn func(RecordInfo) error) (next int64, err error) {
+	if offset < 0 || offset > r.size {
+		return offset, OffsetOutOfRange
+	}
+	buf := bufio.NewReader(r.f.section(offset, r.size-offset))
+	for offset < r.size {
+		data, flags, err := readRecord(buf, offset, r.size-offset, r.f.db.maxRecordSize())
+		if err != nil {
+			return offset, err
+		}
+		meta, _, err := splitMeta(offset, flags, data)
+		if err != nil {
+			return offset, err
+		}
+		info := RecordInfo{
+			Offset:     offset,
+			Size:       recordSize(int64(len(data)), flags),
+			DataSize:   int64(len(data)),
+			Batch:      flags&flagBatch != 0,
+			Compressed: flags&flagCompressed != 0,
+			Encrypted:  flags&flagEncrypted != 0,
+			Trailer:    flags&flagTrailer != 0,
+			Checkpoint: flags&flagCheckpoint != 0,
+			Padding:    flags&flagPadding != 0,
+			Tombstone:  flags&flagTombstone != 0,
+			Meta:       meta,
+		}
+		if err := fn(info); err != nil {
+			if err == Stop {
+				err = nil
+			}
+			return offset, err
+		}
+		offset += info.Size
+	}
+	return offset, nil
+}
+
+// Raw returns the records stored between from and to as they are in the
+// file, ready to be given to AppendRaw.
+func (r *Reader) Raw(from, to int64) ([]byte, error) {
+	if from < 0 || to < from || to > r.size {
+		return nil, OffsetOutOfRange
+	}
+	raw := make([]byte, to-from)
+	if _, err := r.f.readAt(raw, from); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// AppendRaw appends records as returned by Raw, without decoding nor
+// encoding them again: compressed or encrypted records stay so. Every record
+// is checked first, and nothing is written if any of them is damaged. It
+// returns the offset where the first one was written.
+func (f *File) AppendRaw(raw []byte) (offset int64, err error) {
+	var records int64
+	for p := int64(0); p < int64(len(raw)); records++ {
+		data, flags, err := parseRecord(raw[p:], p, f.db.maxRecordSize())
+		if err != nil {
+			return 0, err
+		}
+		p += recordSize(int64(len(data)), flags)
+	}
+	if records == 0 {
+		return f.Size(), nil
+	}
+	offset, err = f.write(raw, records)
+	if err != nil {
+		return 0, err
+	}
+	return offset, f.durable(offset + int64(len(raw)))
+}
@@ -0,0 +1,107 @@
+package appender
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRaw(t *testing.T) {
+	dir := t.TempDir()
+	db := &DB{Keys: Keys{1: bytes.Repeat([]byte{1}, 32)}, Trailer: true}
+	src, err := db.OpenPath(filepath.Join(dir, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	src.AppendMeta(Meta{Type: 7}, []byte("secret"))
+	src.WriteBatch([][]byte{[]byte("a"), []byte("b")})
+
+	// Scanning does not need the keys
+	plain, err := (&DB{}).OpenPath(filepath.Join(dir, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	infos := []RecordInfo{}
+	next, err := plain.Snapshot().Scan(0, func(info RecordInfo) error {
+		infos = append(infos, info)
+		return nil
+	})
+	if err != nil || next != src.Size() {
+		t.Fatal("Expected to scan the whole file. Got", next, err)
+	}
+	if len(infos) != 2 || !infos[0].Encrypted || infos[0].Meta.Type != 7 || !infos[1].Batch || !infos[1].Trailer {
+		t.Error("Unexpected records", infos)
+	}
+	if infos[1].Offset != infos[0].Size {
+		t.Error("Expected the second record after the first. Got", infos[1].Offset)
+	}
+
+	raw, err := src.Snapshot().Raw(0, src.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := db.OpenPath(filepath.Join(dir, "dst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if _, err = dst.AppendRaw(raw); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadAll(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"secret", "a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Damaged records are not appended
+	raw[len(raw)-trailerSize-1] ^= 1
+	if _, err = dst.AppendRaw(raw); err == nil {
+		t.Error("Expected an error appending a damaged record")
+	}
+	if dst.Size() != src.Size() {
+		t.Error("Expected nothing to be written. Got", dst.Size())
+	}
+}
+
+func TestOpenPathReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := (&DB{}).OpenPathReadOnly(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Error("Expected the file not to be created. Got", err)
+	}
+
+	path := filepath.Join(dir, "file")
+	f, err := (&DB{}).OpenPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Append([]byte("hello"))
+	f.Close()
+	w, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	w.Write([]byte{1, 2, 3})
+	w.Close()
+	before, _ := os.ReadFile(path)
+
+	if _, err = (&DB{}).OpenPathReadOnly(path); err == nil {
+		t.Error("Expected an error for the partial record")
+	}
+	// Repair reads the file as if the partial record was not there
+	if f, err = (&DB{Repair: true}).OpenPathReadOnly(path); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if data, err := ReadAll(f); err != nil || len(data) != 1 {
+		t.Error("Expected a record. Got", data, err)
+	}
+	if _, err = f.Append([]byte("no")); err == nil {
+		t.Error("Expected an error appending")
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, before) {
+		t.Error("Expected the file to be left untouched")
+	}
+}
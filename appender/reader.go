@@ -0,0 +1,144 @@
+package appender
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// Reader reads a consistent prefix of a File: the records that were complete
+// when the Reader was created. Readers use positional reads on the file, so
+// any number of them can run at the same time without blocking writers.
+type Reader struct {
+	f    *File
+	size int64
+}
+
+// Snapshot returns a Reader of the records written so far.
+func (f *File) Snapshot() *Reader {
+	return &Reader{f: f, size: f.Size()}
+}
+
+// Size returns the end of the last record seen by the reader.
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// ReadEntry returns the data of the record that starts at offset.
+func (r *Reader) ReadEntry(offset int64) ([]byte, error) {
+	if offset < 0 || offset >= r.size {
+		return nil, OffsetOutOfRange
+	}
+	remaining := r.size - offset
+	data, flags, err := readRecord(r.f.section(offset, remaining), offset, remaining, r.f.db.maxRecordSize())
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagPadding != 0 {
+		return nil, Compacted
+	}
+	if flags&flagBatch != 0 {
+		return nil, BatchRecord
+	}
+	if flags&flagCheckpoint != 0 {
+		return nil, CheckpointRecord
+	}
+	_, list, err := r.f.db.decode(offset, flags, data)
+	if err != nil {
+		return nil, err
+	}
+	return list[0], nil
+}
+
+// ReadBatch returns the entries of the record that starts at offset. A record
+// written with Write is returned as a batch of one entry.
+func (r *Reader) ReadBatch(offset int64) ([][]byte, error) {
+	if offset < 0 || offset >= r.size {
+		return nil, OffsetOutOfRange
+	}
+	remaining := r.size - offset
+	data, flags, err := readRecord(r.f.section(offset, remaining), offset, remaining, r.f.db.maxRecordSize())
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagPadding != 0 {
+		return nil, Compacted
+	}
+	_, list, err := r.f.db.decode(offset, flags, data)
+	return list, err
+}
+
+// Iterate reads all the records of the snapshot.
+func (r *Reader) Iterate(iterator Iterator) error {
+	_, err := r.IterateFrom(0, iterator)
+	return err
+}
+
+// IterateFrom is like Iterate but starts at the record found at offset. It
+// returns the offset following the last record read.
+func (r *Reader) IterateFrom(offset int64, iterator Iterator) (next int64, err error) {
+	return r.WalkFrom(offset, func(e Entry) error {
+		iterator(bytes.NewReader(e.Data))
+		return nil
+	})
+}
+
+// Walk calls fn for every entry of the snapshot until fn returns an error.
+func (r *Reader) Walk(fn WalkFunc) error {
+	_, err := r.WalkFrom(0, fn)
+	return err
+}
+
+// WalkFrom is like Walk but starts at the record found at offset. It returns
+// the offset following the last record walked, or the offset of the record
+// being walked when fn stopped.
+func (r *Reader) WalkFrom(offset int64, fn WalkFunc) (next int64, err error) {
+	next, err = r.walk(offset, nil, fn)
+	if err == Stop {
+		err = nil
+	}
+	return next, err
+}
+
+// walk is WalkFrom without hiding Stop. If match is not nil, records with a
+// meta that does not match are skipped without decoding their data.
+func (r *Reader) walk(offset int64, match func(Meta) bool, fn WalkFunc) (next int64, err error) {
+	if offset < 0 || offset > r.size {
+		return offset, OffsetOutOfRange
+	}
+
+	buf := bufio.NewReader(r.f.section(offset, r.size-offset))
+	for offset < r.size {
+		data, flags, err := readRecord(buf, offset, r.size-offset, r.f.db.maxRecordSize())
+		if err != nil {
+			return offset, err
+		}
+		end := offset + recordSize(int64(len(data)), flags)
+		if err := r.f.db.visit(offset, end, flags, data, match, fn); err != nil {
+			return offset, err
+		}
+		offset = end
+	}
+	return offset, nil
+}
+
+// visit calls fn for the entries of the record at offset, unless its meta
+// does not match.
+func (db *DB) visit(offset, end int64, flags byte, data []byte, match func(Meta) bool, fn WalkFunc) error {
+	meta, body, err := splitMeta(offset, flags, data)
+	if err != nil {
+		return err
+	}
+	if match != nil && !match(meta) {
+		return nil
+	}
+	list, err := db.decodeBody(offset, flags, data[:len(data)-len(body)], body)
+	if err != nil {
+		return err
+	}
+	for _, entry := range list {
+		if err := fn(Entry{Offset: offset, Next: end, Meta: meta, Data: entry}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package appender
+
+import (
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentReaders(t *testing.T) {
+	db := &DB{}
+	db.Remove("user_readers")
+	defer db.Remove("user_readers")
+
+	f, err := db.Open("user_readers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// Every snapshot must be a prefix of what was written
+				r := f.Snapshot()
+				n := 0
+				err := r.Iterate(func(entry io.Reader) {
+					readed, _ := ioutil.ReadAll(entry)
+					if string(readed) != strconv.Itoa(n) {
+						t.Error("Expected", n, "Got", string(readed))
+					}
+					n++
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		if _, err := f.Write([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func BenchmarkWrite(b *testing.B) {
+	benchmarkWrite(b, 0)
+}
+
+// Writers must keep the same pace while readers scan the whole file.
+func BenchmarkWriteWhileIterating(b *testing.B) {
+	benchmarkWrite(b, 4)
+}
+
+func benchmarkWrite(b *testing.B, readers int) {
+	db := &DB{}
+	db.Remove("user_bench")
+	defer db.Remove("user_bench")
+
+	f, err := db.Open("user_bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+
+	data := make([]byte, 128)
+	for i := 0; i < 10000; i++ {
+		f.Write(data)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					f.Iterate(func(entry io.Reader) {})
+				}
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := f.Write(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,260 @@
+package appender
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// Every record is written as an int64 little endian length, a CRC32C of the
+// data and then the data itself. The highest byte of the length holds flags
+// telling how the data must be read. It is always zero in files written
+// before flags existed.
+const headerSize = 8 + 4
+
+const (
+	flagBatch      = 1 << iota // The data holds several length prefixed entries
+	flagCompressed             // The data starts with the ID of the codec
+	flagEncrypted              // The data is sealed with AES-GCM
+	flagTrailer                // The length is repeated after the data
+	flagMeta                   // The data starts with a Meta
+	flagCheckpoint             // A checkpoint, holding no entries
+	flagPadding                // Left by Compact in place of a record, holding zeros
+	flagTombstone              // Written by AppendTombstone, holding no data
+
+	knownFlags = flagBatch | flagCompressed | flagEncrypted | flagTrailer | flagMeta | flagCheckpoint | flagPadding | flagTombstone
+	lengthMask = 1<<56 - 1
+)
+
+// trailerSize is the size of the copy of the length written after the data
+// when flagTrailer is set, so the record can be found from its end.
+const trailerSize = 8
+
+// recordSize returns the bytes taken by a record holding size bytes of data.
+func recordSize(size int64, flags byte) int64 {
+	if flags&flagTrailer != 0 {
+		return headerSize + size + trailerSize
+	}
+	return headerSize + size
+}
+
+// recordOverhead is the most the data of a record can take over what was
+// given to Append: its meta, the ID of the codec, and the key ID, nonce and
+// tag of the encryption.
+const recordOverhead = metaSize + math.MaxUint16 + 1 + keyIDSize + 12 + 16
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptionError is returned when a record can not be read back as it was
+// written. Offset is the position in the file where the record starts.
+type CorruptionError struct {
+	Offset int64
+	Reason string
+
+	short bool // The record goes past the end of the data read
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("appender: corrupt record at offset %d: %s", e.Offset, e.Reason)
+}
+
+// RecordTooLargeError is returned when reading a record larger than
+// DB.MaxRecordSize. Unlike a *CorruptionError it can be caused by a file
+// written with a larger limit, but it may also mean the file is damaged or
+// crafted to exhaust the memory of its readers.
+type RecordTooLargeError struct {
+	Offset int64
+	Size   int64
+	Max    int64
+}
+
+func (e *RecordTooLargeError) Error() string {
+	return fmt.Sprintf("appender: record at offset %d holds %d bytes, more than the maximum of %d", e.Offset, e.Size, e.Max)
+}
+
+// Is makes errors.Is(err, RecordTooLarge) true for both reads and writes.
+func (e *RecordTooLargeError) Is(target error) bool {
+	return target == RecordTooLarge
+}
+
+// encode frames data as a record ready to be written.
+func encode(flags byte, data []byte) []byte {
+	length := uint64(flags)<<56 | uint64(len(data))
+	buf := make([]byte, recordSize(int64(len(data)), flags))
+	binary.LittleEndian.PutUint64(buf, length)
+	binary.LittleEndian.PutUint32(buf[8:], crc32.Checksum(data, castagnoli))
+	copy(buf[headerSize:], data)
+	if flags&flagTrailer != 0 {
+		binary.LittleEndian.PutUint64(buf[headerSize+len(data):], length)
+	}
+	return buf
+}
+
+// decodeHeader returns the length, flags and checksum stored in a record
+// header.
+func decodeHeader(hdr []byte) (size int64, flags byte, sum uint32) {
+	v := binary.LittleEndian.Uint64(hdr)
+	return int64(v & lengthMask), byte(v >> 56), binary.LittleEndian.Uint32(hdr[8:])
+}
+
+// readRecord reads the record that starts at offset. remaining is the number
+// of bytes of the file from offset onwards, so a damaged length never makes
+// us read (or allocate) past the end of the file. Records holding more than
+// max bytes of data, see DB.MaxRecordSize, are not read either.
+func readRecord(r io.Reader, offset, remaining, max int64) (data []byte, flags byte, err error) {
+	if remaining < headerSize {
+		return nil, 0, &CorruptionError{Offset: offset, Reason: "truncated header", short: true}
+	}
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, 0, err
+	}
+	size, flags, sum, err := checkHeader(hdr[:], offset, remaining, max)
+	if err != nil {
+		return nil, 0, err
+	}
+	data = make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, 0, err
+	}
+	if crc32.Checksum(data, castagnoli) != sum {
+		return nil, 0, &CorruptionError{Offset: offset, Reason: "checksum mismatch"}
+	}
+	if flags&flagTrailer != 0 {
+		var trailer [trailerSize]byte
+		if _, err := io.ReadFull(r, trailer[:]); err != nil {
+			return nil, 0, err
+		}
+		if err := checkTrailer(hdr[:], trailer[:], offset); err != nil {
+			return nil, 0, err
+		}
+	}
+	return data, flags, nil
+}
+
+// parseRecord is like readRecord for a record held in memory: buf starts at
+// the record and holds the rest of the file. The data returned is a slice of
+// buf.
+func parseRecord(buf []byte, offset, max int64) (data []byte, flags byte, err error) {
+	if len(buf) < headerSize {
+		return nil, 0, &CorruptionError{Offset: offset, Reason: "truncated header", short: true}
+	}
+	size, flags, sum, err := checkHeader(buf, offset, int64(len(buf)), max)
+	if err != nil {
+		return nil, 0, err
+	}
+	data = buf[headerSize : headerSize+size]
+	if crc32.Checksum(data, castagnoli) != sum {
+		return nil, 0, &CorruptionError{Offset: offset, Reason: "checksum mismatch"}
+	}
+	if flags&flagTrailer != 0 {
+		if err := checkTrailer(buf, buf[headerSize+size:], offset); err != nil {
+			return nil, 0, err
+		}
+	}
+	return data, flags, nil
+}
+
+// checkHeader decodes the header of the record at offset, making sure it
+// fits in the remaining bytes of the file and within max.
+func checkHeader(hdr []byte, offset, remaining, max int64) (size int64, flags byte, sum uint32, err error) {
+	size, flags, sum = decodeHeader(hdr)
+	if flags&^knownFlags != 0 {
+		return 0, 0, 0, &CorruptionError{Offset: offset, Reason: fmt.Sprintf("unknown flags %#x", flags)}
+	}
+	if size > max+recordOverhead {
+		return 0, 0, 0, &RecordTooLargeError{Offset: offset, Size: size, Max: max}
+	}
+	if size > remaining-recordSize(0, flags) {
+		return 0, 0, 0, &CorruptionError{Offset: offset, Reason: fmt.Sprintf("length %d out of range", size), short: true}
+	}
+	return size, flags, sum, nil
+}
+
+func checkTrailer(hdr, trailer []byte, offset int64) error {
+	if binary.LittleEndian.Uint64(trailer) != binary.LittleEndian.Uint64(hdr) {
+		return &CorruptionError{Offset: offset, Reason: "trailer does not match the header"}
+	}
+	return nil
+}
+
+// frame encodes data as a record applying the options of the database. meta
+// may be nil.
+func (db *DB) frame(flags byte, meta *Meta, data []byte) ([]byte, error) {
+	if int64(len(data)) > db.maxRecordSize() {
+		return nil, RecordTooLarge
+	}
+	if db.Trailer {
+		flags |= flagTrailer
+	}
+	if meta == nil && db.Timestamps {
+		meta = &Meta{}
+	}
+	var head []byte
+	if meta != nil {
+		flags |= flagMeta
+		var err error
+		if head, err = meta.encode(); err != nil {
+			return nil, err
+		}
+	}
+
+	if db.Codec != nil {
+		compressed, err := compress(db.Codec, data)
+		if err != nil {
+			return nil, err
+		}
+		if compressed != nil {
+			flags |= flagCompressed
+			data = compressed
+		}
+	}
+	if db.Keys != nil {
+		flags |= flagEncrypted
+		var err error
+		if data, err = encrypt(db.Keys, append([]byte{flags}, head...), data); err != nil {
+			return nil, err
+		}
+	}
+	return encode(flags, append(head, data...)), nil
+}
+
+// decode returns the meta and the entries stored in the data of the record
+// at offset.
+func (db *DB) decode(offset int64, flags byte, data []byte) (Meta, [][]byte, error) {
+	meta, body, err := splitMeta(offset, flags, data)
+	if err != nil {
+		return meta, nil, err
+	}
+	list, err := db.decodeBody(offset, flags, data[:len(data)-len(body)], body)
+	return meta, list, err
+}
+
+// decodeBody returns the entries stored in body, the data of the record
+// that follows its meta.
+func (db *DB) decodeBody(offset int64, flags byte, head, body []byte) ([][]byte, error) {
+	body, err := db.unseal(offset, flags, head, body)
+	if err != nil {
+		return nil, err
+	}
+	return entries(offset, flags, body)
+}
+
+// unseal decrypts and decompresses body, the data of the record that follows
+// its meta.
+func (db *DB) unseal(offset int64, flags byte, head, body []byte) ([]byte, error) {
+	var err error
+	if flags&flagEncrypted != 0 {
+		if body, err = decrypt(db.Keys, offset, append([]byte{flags}, head...), body); err != nil {
+			return nil, err
+		}
+	}
+	if flags&flagCompressed != 0 {
+		if body, err = decompress(offset, body, db.maxRecordSize()); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
@@ -0,0 +1,285 @@
+package appender
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Replication streams the records of a file, as they are stored, from a
+// leader to followers that append them to their own copy. Framing, and so
+// offsets, are the same on both sides.
+//
+// A follower connects and sends the name of the file as a uint16 length and
+// the name, followed by the int64 offset it wants to start from, which is
+// the size of its copy, and the header of its last record, or zeros if it is
+// empty. The leader checks that its record ending at that offset has the
+// same header, and so the same checksum, and answers with a uint32 length and an error
+// message, empty if the request is fine, and then sends chunks of whole
+// records as a uint32 length and the records. Empty chunks are sent when
+// there is nothing new, so both sides notice a dead connection.
+const (
+	replicationChunk     = 1 << 20
+	replicationHeartbeat = time.Second
+	replicationTimeout   = 5 * replicationHeartbeat
+)
+
+// Diverged is returned by Replicate when the copy of the follower is not a
+// prefix of the file of the leader.
+var Diverged = errors.New("appender: replica diverged from the leader")
+
+// ServeReplication sends the files of the database to the followers that
+// connect to ln, until ctx is done. See Replicate.
+func (db *DB) ServeReplication(ctx context.Context, ln net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db.serveFollower(ctx, conn)
+		}()
+	}
+}
+
+func (db *DB) serveFollower(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetReadDeadline(time.Now().Add(replicationTimeout))
+	var n uint16
+	if err := binary.Read(conn, binary.LittleEndian, &n); err != nil {
+		return err
+	}
+	name := make([]byte, n)
+	if _, err := io.ReadFull(conn, name); err != nil {
+		return err
+	}
+	var offset int64
+	if err := binary.Read(conn, binary.LittleEndian, &offset); err != nil {
+		return err
+	}
+	last := make([]byte, headerSize)
+	if _, err := io.ReadFull(conn, last); err != nil {
+		return err
+	}
+
+	f, err := db.openReplicated(string(name))
+	if err == nil {
+		defer f.Close()
+		var hdr []byte
+		if hdr, err = headerBefore(f, offset); err == nil && !bytes.Equal(hdr, last) {
+			err = Diverged
+		}
+	}
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	if err := writeChunk(conn, []byte(msg)); err != nil || msg != "" {
+		return err
+	}
+
+	heartbeat := time.NewTicker(replicationHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		wake := f.changed()
+		r := f.Snapshot()
+		if offset < r.Size() {
+			next, err := r.Scan(offset, func(info RecordInfo) error {
+				if info.Offset > offset && info.Offset+info.Size-offset > replicationChunk {
+					return Stop
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			raw, err := r.Raw(offset, next)
+			if err != nil {
+				return err
+			}
+			conn.SetWriteDeadline(time.Now().Add(replicationTimeout))
+			if err := writeChunk(conn, raw); err != nil {
+				return err
+			}
+			offset = next
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		case <-heartbeat.C:
+			conn.SetWriteDeadline(time.Now().Add(replicationTimeout))
+			if err := writeChunk(conn, nil); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// headerBefore returns the header of the record of f that ends at offset, or
+// zeros if offset is 0. It returns Diverged if no record ends there.
+func headerBefore(f *File, offset int64) ([]byte, error) {
+	if offset < 0 || offset > f.Size() {
+		return nil, Diverged
+	}
+	last := make([]byte, headerSize)
+	end := int64(0)
+	err := f.scan(0, 0, func(record, at int64, hdr []byte) error {
+		if at >= offset {
+			return Stop
+		}
+		n, flags, _ := decodeHeader(hdr)
+		copy(last, hdr)
+		end = at + recordSize(n, flags)
+		return nil
+	})
+	if err != nil && err != Stop {
+		return nil, err
+	}
+	if end != offset {
+		return nil, Diverged
+	}
+	return last, nil
+}
+
+// openReplicated opens a file asked by a follower. Unlike Open it does not
+// create it.
+func (db *DB) openReplicated(name string) (*File, error) {
+	path, err := db.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("appender: no file %q", name)
+	}
+	return db.Open(name)
+}
+
+// Replicate keeps the file name of the database up to date with the one of
+// the leader listening at addr, see ServeReplication. It asks for the records
+// following the ones it already has and appends every record written by the
+// leader until ctx is done or the connection fails. Calling it again resumes
+// from where it was left.
+//
+// The file must not be written by anything else. Records are appended as
+// they are, so encrypted records can only be read with the keys of the
+// leader.
+func (db *DB) Replicate(ctx context.Context, addr, name string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return db.ReplicateConn(ctx, conn, name)
+}
+
+// ReplicateConn is like Replicate over a connection to the leader that is
+// already established, like a TLS one. It closes conn when done.
+func (db *DB) ReplicateConn(ctx context.Context, conn net.Conn, name string) error {
+	defer conn.Close()
+	f, err := db.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	err = db.replicate(conn, f, name)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (db *DB) replicate(conn net.Conn, f *File, name string) error {
+	offset := f.Size()
+	last, err := headerBefore(f, offset)
+	if err != nil {
+		return err
+	}
+	req := binary.LittleEndian.AppendUint16(nil, uint16(len(name)))
+	req = append(req, name...)
+	req = binary.LittleEndian.AppendUint64(req, uint64(offset))
+	req = append(req, last...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// A chunk holds a single record if it does not fit in replicationChunk
+	limit := max(replicationChunk, recordSize(db.maxRecordSize()+recordOverhead, flagTrailer))
+	msg, err := readChunk(conn, limit)
+	if err != nil {
+		return err
+	}
+	if len(msg) > 0 {
+		if string(msg) == Diverged.Error() {
+			return Diverged
+		}
+		return errors.New(string(msg))
+	}
+
+	for {
+		raw, err := readChunk(conn, limit)
+		if err != nil {
+			return err
+		}
+		if len(raw) == 0 {
+			continue
+		}
+		// Written by something else meanwhile
+		if f.Size() != offset {
+			return Diverged
+		}
+		if _, err := f.AppendRaw(raw); err != nil {
+			return err
+		}
+		offset += int64(len(raw))
+	}
+}
+
+func writeChunk(w io.Writer, data []byte) error {
+	buf := binary.LittleEndian.AppendUint32(make([]byte, 0, 4+len(data)), uint32(len(data)))
+	_, err := w.Write(append(buf, data...))
+	return err
+}
+
+// readChunk reads a chunk of at most limit bytes. It fails if nothing,
+// heartbeats included, arrives in time.
+func readChunk(conn net.Conn, limit int64) ([]byte, error) {
+	conn.SetReadDeadline(time.Now().Add(replicationTimeout))
+	var n uint32
+	if err := binary.Read(conn, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if int64(n) > limit {
+		return nil, fmt.Errorf("appender: replication chunk of %d bytes is too large", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,169 @@
+package appender
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func replicationPair(t *testing.T) (leader, follower *DB, addr string, stop func()) {
+	var err error
+	if leader, err = NewDB(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if follower, err = NewDB(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		leader.ServeReplication(ctx, ln)
+	}()
+	return leader, follower, ln.Addr().String(), func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestReplication(t *testing.T) {
+	leader, follower, addr, stop := replicationPair(t)
+	defer stop()
+	leader.Trailer = true
+
+	lf, err := leader.Open("user_replicated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lf.Close()
+	lf.Write([]byte("hello"))
+	lf.WriteBatch([][]byte{[]byte("el"), []byte("mundo")})
+
+	replicate := func() (context.CancelFunc, chan error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		errs := make(chan error, 1)
+		go func() { errs <- follower.Replicate(ctx, addr, "user_replicated") }()
+		return cancel, errs
+	}
+	cancel, errs := replicate()
+
+	ff, err := follower.Open("user_replicated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ff.Close()
+	ctx, stopFollow := context.WithCancel(context.Background())
+	defer stopFollow()
+	c := follow(ff, ctx)
+	expect(t, c, "hello", "el", "mundo")
+
+	// New writes are streamed
+	lf.Write([]byte("!"))
+	expect(t, c, "!")
+
+	// Replication resumes from the last record of the follower
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatal("Expected context.Canceled. Got", err)
+	}
+	lf.Write([]byte("again"))
+	cancel, errs = replicate()
+	defer cancel()
+	expect(t, c, "again")
+
+	if ff.Size() != lf.Size() {
+		t.Error("Expected the same size. Got", ff.Size(), lf.Size())
+	}
+	last, err := ff.ReadLast(1)
+	if err != nil || len(last) != 1 || string(last[0].Data) != "again" {
+		t.Error("Expected the framing to be kept. Got", last, err)
+	}
+}
+
+func TestReplicationErrors(t *testing.T) {
+	leader, follower, addr, stop := replicationPair(t)
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := follower.Replicate(ctx, addr, "user_missing"); err == nil || !strings.Contains(err.Error(), "no file") {
+		t.Error("Expected an error for a missing file. Got", err)
+	}
+
+	lf, err := leader.Open("user_diverged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lf.Close()
+	lf.Write([]byte("hello"))
+	ff, err := follower.Open("user_diverged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	WriteAll(ff, []string{"hello", "world"})
+	ff.Close()
+	if err := follower.Replicate(ctx, addr, "user_diverged"); err != Diverged {
+		t.Error("Expected Diverged. Got", err)
+	}
+
+	// A copy ending in the middle of a record of the leader
+	lf.Write([]byte("hello world"))
+	if ff, err = follower.Open("user_diverged"); err != nil {
+		t.Fatal(err)
+	}
+	ff.Truncate(headerSize + 5)
+	WriteAll(ff, []string{"hello"})
+	ff.Close()
+	if err := follower.Replicate(ctx, addr, "user_diverged"); err != Diverged {
+		t.Error("Expected Diverged. Got", err)
+	}
+
+	// A copy of the same size holding other records
+	follower.Remove("user_diverged")
+	if ff, err = follower.Open("user_diverged"); err != nil {
+		t.Fatal(err)
+	}
+	WriteAll(ff, []string{"HELLO"})
+	ff.Close()
+	if err := follower.Replicate(ctx, addr, "user_diverged"); err != Diverged {
+		t.Error("Expected Diverged. Got", err)
+	}
+}
+
+func TestReplicateConn(t *testing.T) {
+	leader, follower, addr, stop := replicationPair(t)
+	defer stop()
+	lf, err := leader.Open("user_replicated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lf.Close()
+	lf.Write([]byte("hello"))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- follower.ReplicateConn(ctx, conn, "user_replicated") }()
+
+	ff, err := follower.Open("user_replicated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ff.Close()
+	fctx, stopFollow := context.WithCancel(context.Background())
+	defer stopFollow()
+	expect(t, follow(ff, fctx), "hello")
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Error("Expected context.Canceled. Got", err)
+	}
+}
@@ -0,0 +1,154 @@
+package appender
+
+import (
+	"os"
+	"time"
+)
+
+// RetentionEvent describes a segment removed by the retention policy.
+type RetentionEvent struct {
+	Log     string // Directory of the log
+	Segment string // Path of the segment file
+	Base    int64  // Offset of the first record of the segment
+	Size    int64
+	Records int64
+	Reason  string // "bytes", "records" or "age"
+}
+
+// EnforceRetention removes the oldest segments of the log that fall out of
+// the retention policy of the DB. Segments being iterated are taken out of
+// the log right away but their files are only removed when the iteration is
+// done.
+func (l *Log) EnforceRetention() []RetentionEvent {
+	db := l.db
+	var events []RetentionEvent
+
+	l.m.Lock()
+	var bytes, records int64
+	for _, s := range l.segs {
+		size, n := s.stats()
+		bytes += size
+		records += n
+	}
+	for len(l.segs) > 1 {
+		s := l.segs[0]
+		size, n := s.stats()
+
+		reason := ""
+		switch {
+		case db.RetentionBytes > 0 && bytes-size >= db.RetentionBytes:
+			reason = "bytes"
+		case db.RetentionRecords > 0 && records-n >= db.RetentionRecords:
+			reason = "records"
+		case db.RetentionAge > 0 && s.age() >= db.RetentionAge:
+			reason = "age"
+		}
+		if reason == "" {
+			break
+		}
+
+		l.drop()
+		bytes -= size
+		records -= n
+		events = append(events, RetentionEvent{
+			Log:     l.dir,
+			Segment: s.name,
+			Base:    s.base,
+			Size:    size,
+			Records: n,
+			Reason:  reason,
+		})
+	}
+	l.m.Unlock()
+
+	if db.OnRetention != nil {
+		for _, e := range events {
+			db.OnRetention(e)
+		}
+	}
+	return events
+}
+
+// age returns how long ago the segment was last written.
+func (s *segment) age() time.Duration {
+	info, err := s.f.Stat()
+	if err != nil {
+		return 0
+	}
+	return time.Since(info.ModTime())
+}
+
+// keepAge gives the file at path, a copy of s, the modification time of s,
+// so the copy is as old for the retention policy.
+func (s *segment) keepAge(path string) error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(path, time.Time{}, info.ModTime())
+}
+
+// StartJanitor enforces the retention policy on every open log each interval,
+// and compacts them if DB.Compaction is set, until StopJanitor is called.
+func (db *DB) StartJanitor(interval time.Duration) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	if db.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	db.stop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				for _, l := range db.openLogs() {
+					l.EnforceRetention()
+					if db.Compaction {
+						l.Compact()
+					}
+				}
+			}
+		}
+	}()
+}
+
+// StopJanitor stops the janitor started by StartJanitor.
+func (db *DB) StopJanitor() {
+	db.m.Lock()
+	defer db.m.Unlock()
+	if db.stop != nil {
+		close(db.stop)
+		db.stop = nil
+	}
+}
+
+func (db *DB) register(l *Log) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	if db.logs == nil {
+		db.logs = make(map[*Log]bool)
+	}
+	db.logs[l] = true
+}
+
+func (db *DB) unregister(l *Log) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	delete(db.logs, l)
+}
+
+func (db *DB) openLogs() []*Log {
+	db.m.Lock()
+	defer db.m.Unlock()
+	logs := make([]*Log, 0, len(db.logs))
+	for l := range db.logs {
+		logs = append(logs, l)
+	}
+	return logs
+}
@@ -0,0 +1,120 @@
+package appender
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRetentionBytes(t *testing.T) {
+	db := &DB{SegmentSize: headerSize + 5, RetentionBytes: 2 * (headerSize + 5)}
+	db.RemoveLog("log_retention")
+	defer db.RemoveLog("log_retention")
+
+	l, err := db.OpenLog("log_retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err = WriteAllLog(l, []string{"00000", "11111", "22222", "33333", "44444"}); err != nil {
+		t.Fatal(err)
+	}
+
+	events := l.EnforceRetention()
+	if len(events) != 3 {
+		t.Fatal("Expected 3 segments removed. Got", events)
+	}
+	for _, e := range events {
+		if e.Reason != "bytes" {
+			t.Error("Expected reason to be bytes. Got", e.Reason)
+		}
+	}
+
+	data, err := ReadAllLog(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"33333", "44444"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.ReadEntry(0); err == nil {
+		t.Error("Expected the first record to be gone")
+	}
+}
+
+func TestRetentionWhileIterating(t *testing.T) {
+	db := &DB{SegmentSize: headerSize + 5, RetentionRecords: 1}
+	db.RemoveLog("log_retention_iterate")
+	defer db.RemoveLog("log_retention_iterate")
+
+	l, err := db.OpenLog("log_retention_iterate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err = WriteAllLog(l, []string{"00000", "11111", "22222"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var removed []RetentionEvent
+	count := 0
+	err = l.Iterate(func(entry io.Reader) {
+		count++
+		if count != 1 {
+			return
+		}
+		removed = l.EnforceRetention()
+		for _, e := range removed {
+			if _, err := os.Stat(e.Segment); err != nil {
+				t.Error("Segment removed while iterating", err)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Error("Expected to read 3 records. Got", count)
+	}
+	if len(removed) != 2 {
+		t.Fatal("Expected 2 segments removed. Got", removed)
+	}
+	for _, e := range removed {
+		if _, err := os.Stat(e.Segment); !os.IsNotExist(err) {
+			t.Error("Segment should be removed after iterating", e.Segment)
+		}
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	events := make(chan RetentionEvent, 10)
+	db := &DB{
+		SegmentSize:  headerSize + 5,
+		RetentionAge: time.Millisecond,
+		OnRetention:  func(e RetentionEvent) { events <- e },
+	}
+	db.RemoveLog("log_janitor")
+	defer db.RemoveLog("log_janitor")
+
+	l, err := db.OpenLog("log_janitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err = WriteAllLog(l, []string{"00000", "11111"}); err != nil {
+		t.Fatal(err)
+	}
+
+	db.StartJanitor(5 * time.Millisecond)
+	defer db.StopJanitor()
+
+	select {
+	case e := <-events:
+		if e.Reason != "age" || e.Base != 0 {
+			t.Error("Unexpected event", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The janitor did not remove the old segment")
+	}
+}
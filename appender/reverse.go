@@ -0,0 +1,124 @@
+package appender
+
+import (
+	"encoding/binary"
+)
+
+// Reverse calls fn for every entry of the snapshot, from the last one to the
+// first, until fn returns an error. The records must have been written with
+// DB.Trailer; reaching one without trailer fails with NoTrailer.
+func (r *Reader) Reverse(fn WalkFunc) error {
+	err := r.reverse(fn)
+	if err == Stop {
+		err = nil
+	}
+	return err
+}
+
+func (r *Reader) reverse(fn WalkFunc) error {
+	end := r.size
+	for end > 0 {
+		if end < headerSize+trailerSize {
+			return NoTrailer
+		}
+		var trailer [trailerSize]byte
+		if _, err := r.f.readAt(trailer[:], end-trailerSize); err != nil {
+			return err
+		}
+		length := binary.LittleEndian.Uint64(trailer[:])
+		flags := byte(length >> 56)
+		if flags&flagTrailer == 0 || flags&^knownFlags != 0 {
+			return NoTrailer
+		}
+		offset := end - recordSize(int64(length&lengthMask), flags)
+		if offset < 0 {
+			return NoTrailer
+		}
+
+		size := end - offset
+		data, flags, err := readRecord(r.f.section(offset, size), offset, size, r.f.db.maxRecordSize())
+		if err != nil {
+			return err
+		}
+		meta, list, err := r.f.db.decode(offset, flags, data)
+		if err != nil {
+			return err
+		}
+		for i := len(list) - 1; i >= 0; i-- {
+			if err := fn(Entry{Offset: offset, Next: end, Meta: meta, Data: list[i]}); err != nil {
+				return err
+			}
+		}
+		end = offset
+	}
+	return nil
+}
+
+// ReadLast returns the last n entries of the snapshot in the order they were
+// written. See Reverse.
+func (r *Reader) ReadLast(n int) ([]Entry, error) {
+	return readLast(n, r.reverse)
+}
+
+// Reverse walks the entries of the file backwards. See Reader.Reverse.
+func (f *File) Reverse(fn WalkFunc) error {
+	return f.Snapshot().Reverse(fn)
+}
+
+// ReadLast returns the last n entries of the file. See Reader.ReadLast.
+func (f *File) ReadLast(n int) ([]Entry, error) {
+	return f.Snapshot().ReadLast(n)
+}
+
+// Reverse walks the entries of the log backwards. See Reader.Reverse.
+func (l *Log) Reverse(fn WalkFunc) error {
+	err := l.reverse(fn)
+	if err == Stop {
+		err = nil
+	}
+	return err
+}
+
+func (l *Log) reverse(fn WalkFunc) error {
+	segs := l.acquire()
+	defer l.release(segs)
+	for i := len(segs) - 1; i >= 0; i-- {
+		base := segs[i].base
+		err := segs[i].Snapshot().reverse(func(e Entry) error {
+			e.Offset += base
+			e.Next += base
+			return fn(e)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadLast returns the last n entries of the log. See Reader.ReadLast.
+func (l *Log) ReadLast(n int) ([]Entry, error) {
+	return readLast(n, l.reverse)
+}
+
+func readLast(n int, reverse func(WalkFunc) error) ([]Entry, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	// n may be far more than the entries there are
+	last := make([]Entry, 0, min(n, 64))
+	err := reverse(func(e Entry) error {
+		last = append(last, e)
+		if len(last) == n {
+			return Stop
+		}
+		return nil
+	})
+	if err != nil && err != Stop {
+		return nil, err
+	}
+	for i, j := 0, len(last)-1; i < j; i, j = i+1, j-1 {
+		last[i], last[j] = last[j], last[i]
+	}
+	return last, nil
+}
@@ -0,0 +1,102 @@
+package appender
+
+import (
+	"math"
+	"testing"
+)
+
+func TestReverse(t *testing.T) {
+	db := &DB{Trailer: true, Codec: Flate}
+	db.Remove("user_reverse")
+	defer db.Remove("user_reverse")
+
+	f, err := db.Open("user_reverse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	WriteAll(f, []string{"hello", "world"})
+	f.WriteBatch([][]byte{[]byte("el"), []byte("mundo")})
+	WriteAll(f, []string{"es", "un", "test test test test test test"})
+
+	data, err := ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"hello", "world", "el", "mundo", "es", "un", "test test test test test test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	backwards := []string{}
+	err = f.Reverse(func(e Entry) error {
+		backwards = append(backwards, string(e.Data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(backwards, []string{"test test test test test test", "un", "es", "mundo", "el", "world", "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := f.ReadLast(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last) != 3 || string(last[0].Data) != "es" || string(last[2].Data) != "test test test test test test" {
+		t.Error("Unexpected last entries", last)
+	}
+	if data, _ := f.ReadEntry(last[1].Offset); string(data) != "un" {
+		t.Error("Unexpected offset", last[1].Offset)
+	}
+	if all, err := f.ReadLast(math.MaxInt); err != nil || len(all) != 7 {
+		t.Error("Expected every entry. Got", len(all), err)
+	}
+}
+
+func TestReverseWithoutTrailer(t *testing.T) {
+	db := &DB{}
+	db.Remove("user_reverse_old")
+	defer db.Remove("user_reverse_old")
+
+	f, err := db.Open("user_reverse_old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	WriteAll(f, []string{"hello"})
+	db.Trailer = true
+	WriteAll(f, []string{"world"})
+
+	last, err := f.ReadLast(1)
+	if err != nil || len(last) != 1 || string(last[0].Data) != "world" {
+		t.Fatal("Unexpected last entry", last, err)
+	}
+	if _, err = f.ReadLast(2); err != NoTrailer {
+		t.Fatal("Expected NoTrailer. Got", err)
+	}
+}
+
+func TestLogReadLast(t *testing.T) {
+	db := &DB{Trailer: true, SegmentSize: 2 * (headerSize + 5 + trailerSize)}
+	db.RemoveLog("log_reverse")
+	defer db.RemoveLog("log_reverse")
+
+	l, err := db.OpenLog("log_reverse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	WriteAllLog(l, []string{"00000", "11111", "22222", "33333", "44444"})
+
+	last, err := l.ReadLast(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range last {
+		data, err := l.ReadEntry(e.Offset)
+		if err != nil || string(data) != string(e.Data) || string(e.Data) != []string{"11111", "22222", "33333", "44444"}[i] {
+			t.Error("Unexpected entry", i, string(e.Data), string(data), err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package appender
+
+import (
+	"sync"
+	"time"
+)
+
+// SyncPolicy tells when written records are flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncNever leaves flushing to the operating system. Records
+	// acknowledged by Write can be lost on a crash.
+	SyncNever SyncPolicy = iota
+	// SyncAlways flushes the file after every write.
+	SyncAlways
+	// SyncGroup makes concurrent writers share one flush. A write waits up
+	// to DB.SyncInterval for others to join and only returns once the data
+	// of the whole group is durable. Readers see the records of the group
+	// before the flush, so if it fails they are left in the file: the write
+	// returns the error, and writing the data again duplicates it.
+	SyncGroup
+	// SyncBytes flushes the file once DB.SyncThreshold bytes have been
+	// written since the last flush. Writes in between are not durable.
+	SyncBytes
+)
+
+// syncer keeps track of what part of the file is on stable storage.
+type syncer struct {
+	m        sync.Mutex
+	cond     *sync.Cond
+	synced   int64 // Everything before is durable
+	syncing  bool  // A writer is flushing the file for the group
+	unsynced int64 // Bytes written since the last flush (SyncBytes)
+
+	hook func() error // Called instead of fsync, by tests
+}
+
+// Sync flushes everything written so far to stable storage.
+func (f *File) Sync() error {
+	end := f.Size()
+	if err := f.flush(); err != nil {
+		return err
+	}
+	s := &f.syncer
+	s.m.Lock()
+	if end > s.synced {
+		s.synced = end
+	}
+	s.m.Unlock()
+	return nil
+}
+
+// flush syncs the file to stable storage.
+func (f *File) flush() error {
+	if f.syncer.hook != nil {
+		return f.syncer.hook()
+	}
+	return f.f.Sync()
+}
+
+// written is called by write, with f.m held, after appending n bytes and
+// before readers can see them. If it fails the bytes are taken back, so the
+// caller can write them again.
+func (f *File) written(n int64) error {
+	switch f.db.Sync {
+	case SyncAlways:
+		return f.flush()
+	case SyncBytes:
+		if f.syncer.unsynced+n < f.db.SyncThreshold {
+			f.syncer.unsynced += n
+			return nil
+		}
+		if err := f.flush(); err != nil {
+			return err
+		}
+		f.syncer.unsynced = 0
+	}
+	return nil
+}
+
+// durable waits, under SyncGroup, until the file is on stable storage up to
+// end. The first writer to arrive flushes the file for all the others. The
+// records are not taken back if the flush fails, see SyncGroup.
+func (f *File) durable(end int64) error {
+	if f.db.Sync != SyncGroup {
+		return nil
+	}
+	s := &f.syncer
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.cond == nil {
+		s.cond = sync.NewCond(&s.m)
+	}
+
+	for s.synced < end {
+		if s.syncing {
+			s.cond.Wait()
+			continue
+		}
+		s.syncing = true
+		s.m.Unlock()
+
+		time.Sleep(f.db.SyncInterval)
+		target := f.Size()
+		err := f.flush()
+
+		s.m.Lock()
+		s.syncing = false
+		if err == nil && target > s.synced {
+			s.synced = target
+		}
+		s.cond.Broadcast()
+		if err != nil && s.synced < end {
+			return err
+		}
+	}
+	return nil
+}
+
+// markSynced records that the file is durable up to end by other means, like
+// a copy of it that was flushed, and lets the writers waiting for it go.
+func (f *File) markSynced(end int64) {
+	s := &f.syncer
+	s.m.Lock()
+	defer s.m.Unlock()
+	if end > s.synced {
+		s.synced = end
+	}
+	if s.cond != nil {
+		s.cond.Broadcast()
+	}
+}
@@ -0,0 +1,107 @@
+package appender
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSyncPolicies(t *testing.T) {
+	policies := []struct {
+		db      *DB
+		flushes int64 // For 10 records of 13 bytes
+	}{
+		{&DB{Sync: SyncNever}, 0},
+		{&DB{Sync: SyncAlways}, 10},
+		{&DB{Sync: SyncGroup, SyncInterval: 100 * time.Millisecond}, 1},
+		{&DB{Sync: SyncBytes, SyncThreshold: 100}, 1},
+	}
+	for _, p := range policies {
+		db := p.db
+		db.Remove("user_sync")
+		f, err := db.Open("user_sync")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var flushes atomic.Int64
+		f.syncer.hook = func() error {
+			flushes.Add(1)
+			return nil
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if _, err := f.Write([]byte(strconv.Itoa(i))); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		if db.Sync == SyncGroup && f.syncer.synced != f.Size() {
+			t.Error("Writes returned before being durable", f.syncer.synced, f.Size())
+		}
+		if flushes.Load() != p.flushes {
+			t.Errorf("Expected %d flushes with policy %d. Got %d", p.flushes, db.Sync, flushes.Load())
+		}
+		data, err := ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 10 {
+			t.Error("Expected 10 records. Got", data)
+		}
+		f.Close()
+		db.Remove("user_sync")
+	}
+}
+
+func TestSyncFailure(t *testing.T) {
+	db := &DB{Root: t.TempDir(), Sync: SyncAlways}
+	f, err := db.Open("user_sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	failed := errors.New("sync failed")
+	f.syncer.hook = func() error { return failed }
+	if _, err = f.Append([]byte("lost")); err != failed {
+		t.Fatal("Expected the sync error. Got", err)
+	}
+	if f.Size() != 0 {
+		t.Error("Expected the record to be taken back. Got size", f.Size())
+	}
+
+	// Writing it again does not duplicate it
+	f.syncer.hook = nil
+	if _, err = f.Append([]byte("lost")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"lost"}); err != nil {
+		t.Error(err)
+	}
+
+	// A group is already visible when it is flushed, so it is left behind
+	db.Sync = SyncGroup
+	f.syncer.hook = func() error { return failed }
+	if _, err = f.Append([]byte("kept")); err != failed {
+		t.Fatal("Expected the sync error. Got", err)
+	}
+	if data, err = ReadAll(f); err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"lost", "kept"}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,301 @@
+package appender
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Transactions are written to a journal in Root before touching any file.
+// The journal holds, for every file, its name as a uint16 length and the
+// name, its size before the transaction and the number of records as int64,
+// and the records as a uint64 length and the records. It is written as a
+// uint64 length, the entries and a CRC32C of them, and emptied once every
+// file is written.
+//
+// A complete journal found when the database is first used means the
+// transaction may be half applied: it is written again from the sizes it
+// recorded, in the files that do not hold it yet. Records written after it
+// are kept, and a file holding other records at its size is reported as
+// damaged rather than truncated. An incomplete journal means no file was
+// touched, and it is dropped.
+const journalName = ".journal"
+
+// Tx appends records to several files of a database atomically: after a
+// crash either all of them are found or none. Records are only written on
+// Commit.
+type Tx struct {
+	db     *DB
+	writes []txWrite
+}
+
+type txWrite struct {
+	name   string
+	record []byte
+}
+
+// Begin starts a transaction.
+func (db *DB) Begin() *Tx {
+	return &Tx{db: db}
+}
+
+// Append adds data to the end of the file called name when the transaction
+// is committed.
+func (tx *Tx) Append(name string, data []byte) error {
+	return tx.append(name, nil, data)
+}
+
+// AppendMeta is like Append but writes meta with the data, see
+// File.AppendMeta.
+func (tx *Tx) AppendMeta(name string, meta Meta, data []byte) error {
+	return tx.append(name, &meta, data)
+}
+
+func (tx *Tx) append(name string, meta *Meta, data []byte) error {
+	if _, err := tx.db.path(name); err != nil {
+		return err
+	}
+	record, err := tx.db.frame(0, meta, data)
+	if err != nil {
+		return err
+	}
+	tx.writes = append(tx.writes, txWrite{name: name, record: record})
+	return nil
+}
+
+// txFile is what a transaction writes to one file.
+type txFile struct {
+	name    string
+	f       *File
+	size    int64 // Before the transaction
+	records int64
+	raw     []byte
+}
+
+// Commit writes all the records of the transaction and returns their
+// offsets, in the order they were added. Other writes to the files wait
+// until it is done. The records are on stable storage when it returns,
+// whatever the Sync policy of the database is.
+func (tx *Tx) Commit() (offsets []int64, err error) {
+	db := tx.db
+	if err := db.recoverJournal(); err != nil {
+		return nil, err
+	}
+	db.txm.Lock()
+	defer db.txm.Unlock()
+
+	files := map[string]*txFile{}
+	for _, w := range tx.writes {
+		if files[w.name] == nil {
+			f, err := db.Open(w.name)
+			if err != nil {
+				closeTx(files)
+				return nil, err
+			}
+			files[w.name] = &txFile{name: w.name, f: f}
+		}
+	}
+	defer closeTx(files)
+
+	// Lock the files in the same order every time
+	list := make([]*txFile, 0, len(files))
+	for _, t := range files {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	for _, t := range list {
+		t.f.m.Lock()
+		defer t.f.m.Unlock()
+		t.size = t.f.Size()
+	}
+
+	for _, w := range tx.writes {
+		t := files[w.name]
+		offsets = append(offsets, t.size+int64(len(t.raw)))
+		t.raw = append(t.raw, w.record...)
+		t.records++
+	}
+
+	if err := db.writeJournal(list); err != nil {
+		return nil, err
+	}
+	for i, t := range list {
+		_, err := t.f.writeLocked(t.raw, t.records)
+		if err == nil {
+			err = t.f.flush()
+		}
+		if err != nil {
+			db.rollback(list[:i+1])
+			return nil, err
+		}
+	}
+	return offsets, db.clearJournal()
+}
+
+// rollback undoes a commit that failed half way. If it fails too the journal
+// is kept, to finish the transaction the next time the database is used.
+func (db *DB) rollback(list []*txFile) {
+	for _, t := range list {
+		if t.f.truncateLocked(t.size) != nil {
+			return
+		}
+	}
+	db.clearJournal()
+}
+
+func closeTx(files map[string]*txFile) {
+	for _, t := range files {
+		t.f.Close()
+	}
+}
+
+func (db *DB) journalPath() string {
+	return filepath.Join(db.Root, journalName)
+}
+
+func (db *DB) writeJournal(list []*txFile) error {
+	var body []byte
+	for _, t := range list {
+		body = binary.LittleEndian.AppendUint16(body, uint16(len(t.name)))
+		body = append(body, t.name...)
+		body = binary.LittleEndian.AppendUint64(body, uint64(t.size))
+		body = binary.LittleEndian.AppendUint64(body, uint64(t.records))
+		body = binary.LittleEndian.AppendUint64(body, uint64(len(t.raw)))
+		body = append(body, t.raw...)
+	}
+	buf := binary.LittleEndian.AppendUint64(make([]byte, 0, 8+len(body)+4), uint64(len(body)))
+	buf = append(buf, body...)
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum(body, castagnoli))
+
+	j, err := os.OpenFile(db.journalPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = j.Write(buf)
+	if err == nil {
+		err = j.Sync()
+	}
+	if cerr := j.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(db.journalPath()))
+}
+
+// clearJournal marks the transaction as done.
+func (db *DB) clearJournal() error {
+	err := os.Remove(db.journalPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(db.journalPath()))
+}
+
+// readJournal returns the files of the transaction in the journal, or nil if
+// there is none or it was not completely written.
+func (db *DB) readJournal() ([]*txFile, error) {
+	buf, err := os.ReadFile(db.journalPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 8+4 || binary.LittleEndian.Uint64(buf) != uint64(len(buf)-8-4) {
+		return nil, nil
+	}
+	body := buf[8 : len(buf)-4]
+	if crc32.Checksum(body, castagnoli) != binary.LittleEndian.Uint32(buf[len(buf)-4:]) {
+		return nil, nil
+	}
+
+	var list []*txFile
+	for len(body) > 0 {
+		if len(body) < 2 {
+			return nil, &CorruptionError{Reason: "malformed journal"}
+		}
+		n := int(binary.LittleEndian.Uint16(body))
+		if len(body) < 2+n+3*8 {
+			return nil, &CorruptionError{Reason: "malformed journal"}
+		}
+		t := &txFile{name: string(body[2 : 2+n])}
+		body = body[2+n:]
+		t.size = int64(binary.LittleEndian.Uint64(body))
+		t.records = int64(binary.LittleEndian.Uint64(body[8:]))
+		size := binary.LittleEndian.Uint64(body[16:])
+		body = body[24:]
+		if size > uint64(len(body)) {
+			return nil, &CorruptionError{Reason: "malformed journal"}
+		}
+		t.raw, body = body[:size], body[size:]
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+// recoverJournal finishes the transaction that was being committed when the
+// process stopped, if any. It only looks at the journal the first time the
+// database is used.
+func (db *DB) recoverJournal() error {
+	db.jm.Lock()
+	defer db.jm.Unlock()
+	if db.txChecked {
+		return nil
+	}
+
+	list, err := db.readJournal()
+	if err != nil {
+		return err
+	}
+	for _, t := range list {
+		path, err := db.path(t.name)
+		if err != nil {
+			return err
+		}
+		// What is past the size before the transaction can only be part
+		// of it, so it is safe to drop a partial record there.
+		f, err := db.openFileRepair(path, true)
+		if err != nil {
+			return err
+		}
+		if f.Size() < t.size {
+			f.close()
+			return &CorruptionError{Offset: f.Size(), Reason: "file shorter than before the transaction in the journal"}
+		}
+		// The journal is kept if it could not be removed after the commit,
+		// or the commit failed and could not be undone, and the file may
+		// have been written again since then.
+		written := make([]byte, min(f.Size()-t.size, int64(len(t.raw))))
+		if _, err = f.readAt(written, t.size); err == nil && !bytes.HasPrefix(t.raw, written) {
+			err = &CorruptionError{Offset: t.size, Reason: "records not in the transaction in the journal"}
+		}
+		if err == nil && len(written) < len(t.raw) {
+			err = f.Truncate(t.size)
+			if err == nil {
+				_, err = f.write(t.raw, t.records)
+			}
+			if err == nil {
+				err = f.flush()
+			}
+		}
+		if cerr := f.close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := db.clearJournal(); err != nil {
+		return err
+	}
+	db.txChecked = true
+	return nil
+}
@@ -0,0 +1,177 @@
+package appender
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestTx(t *testing.T) {
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := db.Open("user_sender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	sender.Write([]byte("hello"))
+
+	tx := db.Begin()
+	tx.Append("user_sender", []byte("sent 10"))
+	tx.Append("user_recipient", []byte("received 10"))
+	tx.AppendMeta("user_sender", Meta{Type: 1}, []byte("fee 1"))
+	if err = tx.Append("", []byte("nobody")); err != InvalidName {
+		t.Error("Expected InvalidName. Got", err)
+	}
+	offsets, err := tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != 3 || offsets[0] != headerSize+5 || offsets[1] != 0 || offsets[2] != offsets[0]+headerSize+7 {
+		t.Error("Unexpected offsets", offsets)
+	}
+
+	data, err := ReadAll(sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"hello", "sent 10", "fee 1"}); err != nil {
+		t.Error(err)
+	}
+	if _, err = os.Stat(db.journalPath()); !os.IsNotExist(err) {
+		t.Error("Expected the journal to be removed")
+	}
+}
+
+// crashTx leaves the journal of a transaction as if the process stopped
+// right after writing it and half a record to the sender. If the journal is
+// not complete, nothing is written to the files.
+func crashTx(t *testing.T, complete bool) *DB {
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := db.Open("user_sender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	sender.Write([]byte("hello"))
+
+	sent := encode(0, []byte("sent 10"))
+	list := []*txFile{
+		{name: "user_recipient", records: 1, raw: encode(0, []byte("received 10"))},
+		{name: "user_sender", size: sender.Size(), records: 1, raw: sent},
+	}
+	if err = db.writeJournal(list); err != nil {
+		t.Fatal(err)
+	}
+	if !complete {
+		info, _ := os.Stat(db.journalPath())
+		os.Truncate(db.journalPath(), info.Size()-1)
+		return &DB{Root: db.Root}
+	}
+	sender.f.Write(sent[:headerSize+2])
+	return &DB{Root: db.Root}
+}
+
+func TestTxReplay(t *testing.T) {
+	db := crashTx(t, true)
+	sender, err := db.Open("user_sender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	data, err := ReadAll(sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"hello", "sent 10"}); err != nil {
+		t.Error(err)
+	}
+
+	recipient, err := db.Open("user_recipient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recipient.Close()
+	if data, err = ReadAll(recipient); err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"received 10"}); err != nil {
+		t.Error(err)
+	}
+	if _, err = os.Stat(db.journalPath()); !os.IsNotExist(err) {
+		t.Error("Expected the journal to be removed")
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	db := crashTx(t, false)
+	sender, err := db.Open("user_sender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	data, err := ReadAll(sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"hello"}); err != nil {
+		t.Error(err)
+	}
+	if _, err = os.Stat(db.journalPath()); !os.IsNotExist(err) {
+		t.Error("Expected the journal to be removed")
+	}
+}
+
+func TestTxStaleJournal(t *testing.T) {
+	db, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := db.Open("user_sender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender.Write([]byte("hello"))
+
+	// The journal of a commit that could not remove it
+	list := []*txFile{{name: "user_sender", size: sender.Size(), records: 1, raw: encode(0, []byte("sent 10"))}}
+	if err = db.writeJournal(list); err != nil {
+		t.Fatal(err)
+	}
+	sender.Write([]byte("sent 10"))
+	sender.Write([]byte("later"))
+	sender.Close()
+
+	db = &DB{Root: db.Root}
+	if sender, err = db.Open("user_sender"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadAll(sender)
+	sender.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Compare(data, []string{"hello", "sent 10", "later"}); err != nil {
+		t.Error(err)
+	}
+
+	// Other records where the transaction should be are not destroyed
+	path, _ := db.path("user_sender")
+	raw, _ := os.ReadFile(path)
+	list[0].raw = encode(0, []byte("sent 20"))
+	if err = db.writeJournal(list); err != nil {
+		t.Fatal(err)
+	}
+	db = &DB{Root: db.Root}
+	if _, err = db.Open("user_sender"); err == nil {
+		t.Error("Expected an error for records not in the transaction")
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, raw) {
+		t.Error("The file should be left untouched")
+	}
+}
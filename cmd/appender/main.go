@@ -0,0 +1,294 @@
+// Command appender inspects and repairs files written by the appender
+// package.
+//
+// Usage:
+//
+//	appender dump [-format raw|hex|json] [-from offset] file
+//	appender stat file
+//	appender verify file
+//	appender truncate [-at offset] file
+//	appender copy [-from offset] [-to offset] [-n records] file newfile
+//	appender migrate file
+//
+// Encrypted records can be scanned by stat, verify, truncate and copy, but
+// dump needs their keys and stops at the first one.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"github.com/guillermo/go/appender"
+)
+
+var commands = map[string]func(args []string, out io.Writer) error{
+	"dump":     dump,
+	"stat":     stat,
+	"verify":   verify,
+	"truncate": truncate,
+	"copy":     copyRecords,
+	"migrate":  migrate,
+}
+
+// usage is returned when the command line can not be understood.
+var usage = errors.New("usage: appender dump|stat|verify|truncate|copy|migrate [flags] file")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return usage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return usage
+	}
+	return cmd(args[1:], out)
+}
+
+// open opens the file named in the only argument left by flags. It is
+// opened read only, so inspecting a file never creates nor changes it.
+func open(flags *flag.FlagSet, args []string, db *appender.DB) (*appender.File, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() != 1 {
+		return nil, usage
+	}
+	return db.OpenPathReadOnly(flags.Arg(0))
+}
+
+// jsonBytes is how bytes are dumped as JSON: a string if they are valid
+// UTF-8, or else base64 in another field, since encoding/json would replace
+// the invalid bytes.
+func jsonBytes(b []byte) (text *string, binary []byte) {
+	if utf8.Valid(b) {
+		s := string(b)
+		return &s, nil
+	}
+	return nil, b
+}
+
+func dump(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("dump", flag.ContinueOnError)
+	format := flags.String("format", "json", "Output format: raw, hex or json")
+	from := flags.Int64("from", 0, "Offset of the first record to dump")
+	f, err := open(flags, args, &appender.DB{})
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var print func(e appender.Entry) error
+	switch *format {
+	case "raw":
+		print = func(e appender.Entry) error {
+			_, err := out.Write(e.Data)
+			return err
+		}
+	case "hex":
+		print = func(e appender.Entry) error {
+			_, err := fmt.Fprintf(out, "offset %d, %d bytes\n%s", e.Offset, len(e.Data), hex.Dump(e.Data))
+			return err
+		}
+	case "json":
+		enc := json.NewEncoder(out)
+		print = func(e appender.Entry) error {
+			record := struct {
+				Offset     int64   `json:"offset"`
+				Time       int64   `json:"time,omitempty"`
+				Type       uint8   `json:"type,omitempty"`
+				Key        *string `json:"key,omitempty"`
+				KeyBase64  []byte  `json:"key_base64,omitempty"`
+				Data       *string `json:"data,omitempty"`
+				DataBase64 []byte  `json:"data_base64,omitempty"`
+			}{Offset: e.Offset, Type: e.Meta.Type}
+			if len(e.Meta.Key) > 0 {
+				record.Key, record.KeyBase64 = jsonBytes(e.Meta.Key)
+			}
+			record.Data, record.DataBase64 = jsonBytes(e.Data)
+			if !e.Meta.Time.IsZero() {
+				record.Time = e.Meta.Time.UnixNano()
+			}
+			return enc.Encode(record)
+		}
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	_, err = f.WalkFrom(*from, print)
+	return err
+}
+
+func stat(args []string, out io.Writer) error {
+	f, err := open(flag.NewFlagSet("stat", flag.ContinueOnError), args, &appender.DB{})
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var records, data, batches, compressed, encrypted int64
+	minSize, maxSize := int64(-1), int64(0)
+	_, err = f.Snapshot().Scan(0, func(r appender.RecordInfo) error {
+		records++
+		data += r.DataSize
+		if minSize < 0 || r.DataSize < minSize {
+			minSize = r.DataSize
+		}
+		maxSize = max(maxSize, r.DataSize)
+		if r.Batch {
+			batches++
+		}
+		if r.Compressed {
+			compressed++
+		}
+		if r.Encrypted {
+			encrypted++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "version: %d\n", f.Version())
+	fmt.Fprintf(out, "size: %d bytes\n", f.Size())
+	fmt.Fprintf(out, "records: %d (%d batches, %d compressed, %d encrypted)\n", records, batches, compressed, encrypted)
+	if records > 0 {
+		fmt.Fprintf(out, "data: %d bytes, %d per record (min %d, max %d)\n", data, data/records, minSize, maxSize)
+		fmt.Fprintf(out, "overhead: %d bytes\n", f.Size()-data)
+	}
+	return nil
+}
+
+func verify(args []string, out io.Writer) error {
+	f, err := open(flag.NewFlagSet("verify", flag.ContinueOnError), args, &appender.DB{})
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var records int64
+	if _, err = f.Snapshot().Scan(0, func(appender.RecordInfo) error {
+		records++
+		return nil
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "ok: %d records, %d bytes\n", records, f.Size())
+	return nil
+}
+
+func truncate(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("truncate", flag.ContinueOnError)
+	at := flags.Int64("at", -1, "Offset to truncate at. By default, the first damaged record.")
+	// Find where to truncate before changing anything. Repair ignores a
+	// partial record at the end, which goes anyway.
+	db := &appender.DB{Repair: true}
+	f, err := open(flags, args, db)
+	if err != nil {
+		return err
+	}
+	end, err := f.Snapshot().Scan(0, func(r appender.RecordInfo) error {
+		if *at >= 0 && r.Offset >= *at {
+			return appender.Stop
+		}
+		return nil
+	})
+	size := f.Size()
+	f.Close()
+	if _, ok := err.(*appender.CorruptionError); err != nil && (!ok || *at >= 0) {
+		return err
+	}
+	if *at >= 0 && end != *at {
+		return fmt.Errorf("offset %d is not the beginning of a record", *at)
+	}
+
+	if f, err = db.OpenPath(flags.Arg(0)); err != nil {
+		return err
+	}
+	if err = f.Truncate(end); err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Fprintf(out, "truncated at %d, %d bytes removed\n", end, size-end)
+	return f.Close()
+}
+
+func copyRecords(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("copy", flag.ContinueOnError)
+	from := flags.Int64("from", 0, "Offset of the first record to copy")
+	to := flags.Int64("to", -1, "Offset where to stop copying. By default, the end of the file.")
+	n := flags.Int64("n", -1, "Maximum number of records to copy")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return usage
+	}
+	db := &appender.DB{}
+	src, err := db.OpenPathReadOnly(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	r := src.Snapshot()
+	var records int64
+	end, err := r.Scan(*from, func(info appender.RecordInfo) error {
+		if *to >= 0 && info.Offset >= *to || *n >= 0 && records == *n {
+			return appender.Stop
+		}
+		records++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	raw, err := r.Raw(*from, end)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(flags.Arg(1)); err == nil {
+		return fmt.Errorf("%s already exists", flags.Arg(1))
+	}
+	dst, err := db.OpenPath(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	if _, err = dst.AppendRaw(raw); err != nil {
+		dst.Close()
+		return err
+	}
+	if err = dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	fmt.Fprintf(out, "copied %d records, %d bytes\n", records, len(raw))
+	return dst.Close()
+}
+
+func migrate(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usage
+	}
+	if err := (&appender.DB{}).MigratePath(flags.Arg(0)); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s is at version %d\n", flags.Arg(0), appender.FormatVersion)
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/guillermo/go/appender"
+)
+
+func write(t *testing.T, path string, entries ...string) []int64 {
+	db := &appender.DB{}
+	f, err := db.OpenPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	offsets := []int64{}
+	for _, e := range entries {
+		offset, err := f.Append([]byte(e))
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, offset)
+	}
+	return offsets
+}
+
+func output(t *testing.T, args ...string) string {
+	out := &bytes.Buffer{}
+	if err := run(args, out); err != nil {
+		t.Fatal(args, err)
+	}
+	return out.String()
+}
+
+func TestDump(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	offsets := write(t, path, "hello", "wor\"ld")
+
+	if out := output(t, "dump", "-format", "raw", path); out != "hellowor\"ld" {
+		t.Error("Unexpected raw dump", out)
+	}
+	if out := output(t, "dump", "-from", "17", path); out != `{"offset":17,"data":"wor\"ld"}`+"\n" {
+		t.Error("Unexpected json dump", out, offsets)
+	}
+	if out := output(t, "dump", "-format", "hex", path); !strings.Contains(out, "68 65 6c 6c 6f") {
+		t.Error("Unexpected hex dump", out)
+	}
+	if err := run([]string{"dump", "-format", "xml", path}, &bytes.Buffer{}); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+	if err := run([]string{"unknown"}, &bytes.Buffer{}); err != usage {
+		t.Error("Expected usage. Got", err)
+	}
+
+	// Binary data is not mangled
+	write(t, path, "\xff\xfea")
+	out := output(t, "dump", "-from", "35", path)
+	if out != `{"offset":35,"data_base64":"//5h"}`+"\n" {
+		t.Error("Unexpected json dump of binary data", out)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+	for _, cmd := range []string{"dump", "stat", "verify", "truncate"} {
+		if err := run([]string{cmd, missing}, &bytes.Buffer{}); err == nil {
+			t.Error("Expected an error for a missing file with", cmd)
+		}
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Error("Expected the file not to be created")
+	}
+}
+
+func TestStat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	write(t, path, "a", "bbb")
+	out := output(t, "stat", path)
+	if !strings.Contains(out, "records: 2") || !strings.Contains(out, "data: 4 bytes, 2 per record (min 1, max 3)") {
+		t.Error("Unexpected stat", out)
+	}
+}
+
+func TestVerifyAndTruncate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	offsets := write(t, path, "hello", "world", "!")
+	if out := output(t, "verify", path); out != "ok: 3 records, 47 bytes\n" {
+		t.Error("Unexpected verify", out)
+	}
+
+	// Damage the second record. Offsets do not count the file header.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("W"), info.Size()-47+offsets[1]+12)
+	f.Close()
+	err = run([]string{"verify", path}, &bytes.Buffer{})
+	if e, ok := err.(*appender.CorruptionError); !ok || e.Offset != offsets[1] {
+		t.Fatal("Expected a *CorruptionError. Got", err)
+	}
+
+	if out := output(t, "truncate", path); out != "truncated at 17, 30 bytes removed\n" {
+		t.Error("Unexpected truncate", out)
+	}
+	if out := output(t, "verify", path); out != "ok: 1 records, 17 bytes\n" {
+		t.Error("Unexpected verify", out)
+	}
+	if err = run([]string{"truncate", "-at", "3", path}, &bytes.Buffer{}); err == nil {
+		t.Error("Expected an error truncating in the middle of a record")
+	}
+
+	// A wrong offset leaves a partial record at the end alone
+	info, _ = os.Stat(path)
+	f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{1, 2, 3})
+	f.Close()
+	if err = run([]string{"truncate", "-at", "3", path}, &bytes.Buffer{}); err == nil {
+		t.Error("Expected an error truncating in the middle of a record")
+	}
+	if after, _ := os.Stat(path); after.Size() != info.Size()+3 {
+		t.Error("Expected the file to be left untouched. Got", after.Size())
+	}
+	if out := output(t, "truncate", "-at", "17", path); out != "truncated at 17, 0 bytes removed\n" {
+		t.Error("Unexpected truncate", out)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	offsets := write(t, src, "0", "1", "2", "3")
+
+	if out := output(t, "copy", "-from", "13", "-n", "2", src, dst); out != "copied 2 records, 26 bytes\n" {
+		t.Error("Unexpected copy", out, offsets)
+	}
+	if out := output(t, "dump", "-format", "raw", dst); out != "12" {
+		t.Error("Unexpected copied records", out)
+	}
+	if err := run([]string{"copy", src, dst}, &bytes.Buffer{}); err == nil {
+		t.Error("Expected an error copying over an existing file")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	// A file written before headers existed is just its records
+	record := []byte{5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 'h', 'e', 'l', 'l', 'o'}
+	binary.LittleEndian.PutUint32(record[8:], crc32.Checksum(record[12:], crc32.MakeTable(crc32.Castagnoli)))
+	if err := os.WriteFile(path, record, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if out := output(t, "stat", path); !strings.Contains(out, "version: 0") {
+		t.Error("Expected version 0", out)
+	}
+	if out := output(t, "migrate", path); out != path+" is at version 1\n" {
+		t.Error("Unexpected migrate", out)
+	}
+	if out := output(t, "stat", path); !strings.Contains(out, "version: 1") {
+		t.Error("Expected version 1", out)
+	}
+	if out := output(t, "dump", path); out != `{"offset":0,"data":"hello"}`+"\n" {
+		t.Error("Unexpected dump", out)
+	}
+
+	// Files written before records had checksums are only read once migrated
+	legacy := append(binary.LittleEndian.AppendUint64(nil, 5), "hello"...)
+	if err := os.WriteFile(path, legacy, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"stat", path}, &bytes.Buffer{}); err != appender.LegacyFormat {
+		t.Error("Expected LegacyFormat. Got", err)
+	}
+	output(t, "migrate", path)
+	if out := output(t, "dump", path); out != `{"offset":0,"data":"hello"}`+"\n" {
+		t.Error("Unexpected dump", out)
+	}
+}